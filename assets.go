@@ -0,0 +1,10 @@
+package main
+
+import "embed"
+
+// embeddedAssets holds the page templates, message catalogs and static files
+// so the binary does not depend on its working directory. Set ASSETS_DIR to
+// serve them from disk instead while editing.
+//
+//go:embed templates locales all:public
+var embeddedAssets embed.FS
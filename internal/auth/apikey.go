@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+)
+
+// Keys look like "vlk_<prefix>_<secret>".
+const keyScheme = "vlk_"
+
+var ErrInvalidKey = errors.New("invalid API key")
+
+var keyLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+func randomString(length int) (string, error) {
+	b := make([]rune, length)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(keyLetters))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = keyLetters[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether token has the shape of a key issued here, as
+// opposed to a token issued by the VitaByte config service.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, keyScheme)
+}
+
+func splitKey(key string) (prefix string, ok bool) {
+	rest, found := strings.CutPrefix(key, keyScheme)
+	if !found {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// CreateKey issues a new key for the merchant, creating the merchant if needed.
+// The returned plaintext key is not stored and cannot be recovered later.
+func CreateKey(db *gorm.DB, merchantID, name string) (string, *models.APIKey, error) {
+	if strings.TrimSpace(merchantID) == "" {
+		return "", nil, errors.New("merchant_id is required")
+	}
+	prefix, err := randomString(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	full := keyScheme + prefix + "_" + secret
+
+	key := models.APIKey{
+		MerchantID: merchantID,
+		Name:       name,
+		Prefix:     prefix,
+		Hash:       HashKey(full),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		m := models.Merchant{MerchantID: merchantID}
+		if err := tx.FirstOrCreate(&m, "merchant_id = ?", merchantID).Error; err != nil {
+			return fmt.Errorf("create merchant: %w", err)
+		}
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return full, &key, nil
+}
+
+// Authenticate returns the active key matching token.
+func Authenticate(db *gorm.DB, token string) (*models.APIKey, error) {
+	prefix, ok := splitKey(token)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	var key models.APIKey
+	if err := db.First(&key, "prefix = ?", prefix).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(HashKey(token))) != 1 || key.RevokedAt != nil {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now()
+	db.Model(&key).UpdateColumn("last_used_at", now)
+	key.LastUsedAt = &now
+	return &key, nil
+}
@@ -0,0 +1,338 @@
+// Package config loads the service configuration from defaults, an optional
+// config file and the environment, in that order of precedence (lowest
+// first), and validates it once at startup.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvLocal      = "local"
+	EnvStaging    = "staging"
+	EnvProduction = "production"
+)
+
+type Config struct {
+	Env         string
+	DatabaseURL string
+
+	Server   Server
+	Gateway  Gateway
+	Upstream Upstream
+	Pages    Pages
+	Workers  Workers
+}
+
+type Server struct {
+	Addr            string
+	ShutdownTimeout time.Duration
+	// DrainTimeout is how long shutdown waits, after the listener has
+	// closed, for gateway calls already under way to be recorded.
+	DrainTimeout time.Duration
+	CORSOrigins  []string
+	// AssetsDir serves templates and public files from disk with reload
+	// instead of the copies embedded in the binary.
+	AssetsDir string
+	// ReadyTimeout bounds each dependency check made by /readyz.
+	ReadyTimeout time.Duration
+}
+
+type Gateway struct {
+	Provider       string // "datacap" or "mock"
+	DatacapBaseURL string
+	// Timeout bounds each call to the processor.
+	Timeout time.Duration
+}
+
+// Upstream holds the VitaByte/VitaPay services this one calls.
+type Upstream struct {
+	// ConfigURL resolves legacy bearer tokens to a merchant.
+	ConfigURL string
+	// CheckURL is the base of the page status endpoint, called as
+	// CheckURL/<merchant_id>/<page_uid>.
+	CheckURL string
+	Timeout  time.Duration
+	// ReadyCheck makes /readyz also require ConfigURL to answer.
+	ReadyCheck bool
+}
+
+type Pages struct {
+	UIDLength    int
+	DefaultRvcID string
+}
+
+type Workers struct {
+	AuthSweepInterval   time.Duration
+	AuthVoidAfter       time.Duration
+	ExpirySweepInterval time.Duration
+	WebhookInterval     time.Duration
+	// ReconcileInterval is how often charge attempts with a lost outcome are
+	// looked up; ReconcileAfter is how old one must be, and has to exceed
+	// Gateway.Timeout so live requests are left alone.
+	ReconcileInterval time.Duration
+	ReconcileAfter    time.Duration
+}
+
+// setting is one configuration value, addressed as key in the config file
+// and env in the environment.
+type setting struct {
+	key, env string
+	set      func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"env", "APP_ENV", str(func(c *Config) *string { return &c.Env })},
+	{"database.url", "DATABASE_URL", str(func(c *Config) *string { return &c.DatabaseURL })},
+
+	{"server.addr", "ADDR", str(func(c *Config) *string { return &c.Server.Addr })},
+	{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", dur(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"server.drain_timeout", "DRAIN_TIMEOUT", dur(func(c *Config) *time.Duration { return &c.Server.DrainTimeout })},
+	{"server.cors_origins", "CORS_ORIGINS", list(func(c *Config) *[]string { return &c.Server.CORSOrigins })},
+	{"server.assets_dir", "ASSETS_DIR", str(func(c *Config) *string { return &c.Server.AssetsDir })},
+	{"server.ready_timeout", "READY_TIMEOUT", dur(func(c *Config) *time.Duration { return &c.Server.ReadyTimeout })},
+
+	{"gateway.provider", "PAYMENT_GATEWAY", str(func(c *Config) *string { return &c.Gateway.Provider })},
+	{"gateway.datacap_base_url", "DATACAP_BASE_URL", str(func(c *Config) *string { return &c.Gateway.DatacapBaseURL })},
+	{"gateway.timeout", "GATEWAY_TIMEOUT", dur(func(c *Config) *time.Duration { return &c.Gateway.Timeout })},
+
+	{"upstream.config_url", "VITABYTE_CONFIG_URL", str(func(c *Config) *string { return &c.Upstream.ConfigURL })},
+	{"upstream.check_url", "VITAPAY_CHECK_URL", str(func(c *Config) *string { return &c.Upstream.CheckURL })},
+	{"upstream.timeout", "UPSTREAM_TIMEOUT", dur(func(c *Config) *time.Duration { return &c.Upstream.Timeout })},
+	{"upstream.ready_check", "READY_CHECK_UPSTREAM", boolean(func(c *Config) *bool { return &c.Upstream.ReadyCheck })},
+
+	{"pages.uid_length", "PAGE_UID_LENGTH", num(func(c *Config) *int { return &c.Pages.UIDLength })},
+	{"pages.default_rvc_id", "DEFAULT_RVC_ID", str(func(c *Config) *string { return &c.Pages.DefaultRvcID })},
+
+	{"workers.auth_sweep_interval", "AUTH_SWEEP_INTERVAL", dur(func(c *Config) *time.Duration { return &c.Workers.AuthSweepInterval })},
+	{"workers.auth_void_after", "AUTH_VOID_AFTER", dur(func(c *Config) *time.Duration { return &c.Workers.AuthVoidAfter })},
+	{"workers.expiry_sweep_interval", "EXPIRY_SWEEP_INTERVAL", dur(func(c *Config) *time.Duration { return &c.Workers.ExpirySweepInterval })},
+	{"workers.webhook_interval", "WEBHOOK_INTERVAL", dur(func(c *Config) *time.Duration { return &c.Workers.WebhookInterval })},
+	{"workers.reconcile_interval", "RECONCILE_INTERVAL", dur(func(c *Config) *time.Duration { return &c.Workers.ReconcileInterval })},
+	{"workers.reconcile_after", "RECONCILE_AFTER", dur(func(c *Config) *time.Duration { return &c.Workers.ReconcileAfter })},
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error { *field(c) = v; return nil }
+}
+
+func num(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("not an integer: %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("not a boolean: %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func dur(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("not a duration: %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var out []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		*field(c) = out
+		return nil
+	}
+}
+
+// Default is the configuration for local development.
+func Default() *Config {
+	return &Config{
+		Env: EnvLocal,
+		Server: Server{
+			Addr:            ":8080",
+			ShutdownTimeout: 10 * time.Second,
+			DrainTimeout:    30 * time.Second,
+			ReadyTimeout:    2 * time.Second,
+			CORSOrigins:     []string{"http://localhost:8080"},
+		},
+		Gateway: Gateway{
+			Provider:       "datacap",
+			DatacapBaseURL: "https://api.vitapay.com/v1/credit",
+			Timeout:        15 * time.Second,
+		},
+		Upstream: Upstream{
+			ConfigURL: "https://api.vitabyte.info/api/config",
+			CheckURL:  "https://api.vitapay.com/check",
+			Timeout:   10 * time.Second,
+		},
+		Pages: Pages{
+			UIDLength:    10,
+			DefaultRvcID: "1",
+		},
+		Workers: Workers{
+			AuthSweepInterval:   time.Hour,
+			AuthVoidAfter:       7 * 24 * time.Hour,
+			ExpirySweepInterval: time.Minute,
+			WebhookInterval:     5 * time.Second,
+			ReconcileInterval:   time.Minute,
+			ReconcileAfter:      5 * time.Minute,
+		},
+	}
+}
+
+// Load builds the configuration. The file named by CONFIG_FILE, if set, is
+// read first; environment variables override it.
+func Load() (*Config, error) {
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.apply(values, path); err != nil {
+			return nil, err
+		}
+	}
+
+	env := map[string]string{}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			env[s.key] = v
+		}
+	}
+	// PORT predates ADDR; honour it when ADDR is not set.
+	if _, ok := env["server.addr"]; !ok {
+		if port := os.Getenv("PORT"); port != "" {
+			env["server.addr"] = ":" + port
+		}
+	}
+	if err := cfg.apply(env, "environment"); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) apply(values map[string]string, source string) error {
+	known := map[string]setting{}
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, ok := known[k]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", source, k)
+		}
+		if err := s.set(c, values[k]); err != nil {
+			return fmt.Errorf("%s: %s (%s): %w", source, k, s.env, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	switch c.Env {
+	case EnvLocal, EnvStaging, EnvProduction:
+	default:
+		bad("env must be %s, %s or %s", EnvLocal, EnvStaging, EnvProduction)
+	}
+	if c.DatabaseURL == "" {
+		bad("database.url (DATABASE_URL) is required")
+	}
+	if c.Server.Addr == "" {
+		bad("server.addr (ADDR) is required")
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		bad("server.cors_origins (CORS_ORIGINS) must list at least one origin")
+	}
+	if c.Env == EnvProduction && slices.Contains(c.Server.CORSOrigins, "*") {
+		bad("server.cors_origins (CORS_ORIGINS) must not be \"*\" in production")
+	}
+
+	switch c.Gateway.Provider {
+	case "datacap":
+	case "mock":
+		if c.Env == EnvProduction {
+			bad("gateway.provider mock is not allowed in production")
+		}
+	default:
+		bad("gateway.provider (PAYMENT_GATEWAY) must be datacap or mock")
+	}
+	for key, v := range map[string]string{
+		"gateway.datacap_base_url": c.Gateway.DatacapBaseURL,
+		"upstream.config_url":      c.Upstream.ConfigURL,
+		"upstream.check_url":       c.Upstream.CheckURL,
+	} {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			bad("%s must be an absolute http(s) URL", key)
+		}
+	}
+
+	if c.Pages.UIDLength < 6 || c.Pages.UIDLength > 64 {
+		bad("pages.uid_length must be between 6 and 64")
+	}
+	if c.Pages.DefaultRvcID == "" {
+		bad("pages.default_rvc_id must not be empty")
+	}
+
+	for key, d := range map[string]time.Duration{
+		"server.shutdown_timeout":       c.Server.ShutdownTimeout,
+		"server.drain_timeout":          c.Server.DrainTimeout,
+		"server.ready_timeout":          c.Server.ReadyTimeout,
+		"gateway.timeout":               c.Gateway.Timeout,
+		"upstream.timeout":              c.Upstream.Timeout,
+		"workers.auth_sweep_interval":   c.Workers.AuthSweepInterval,
+		"workers.auth_void_after":       c.Workers.AuthVoidAfter,
+		"workers.expiry_sweep_interval": c.Workers.ExpirySweepInterval,
+		"workers.webhook_interval":      c.Workers.WebhookInterval,
+		"workers.reconcile_interval":    c.Workers.ReconcileInterval,
+	} {
+		if d <= 0 {
+			bad("%s must be positive", key)
+		}
+	}
+	if c.Workers.ReconcileAfter <= c.Gateway.Timeout {
+		bad("workers.reconcile_after must be longer than gateway.timeout")
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() *Config {
+	c := Default()
+	c.DatabaseURL = "postgres://localhost/vitalink"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"defaults", func(*Config) {}, nil},
+		{"missing database", func(c *Config) { c.DatabaseURL = "" }, []string{"database.url"}},
+		{"unknown env", func(c *Config) { c.Env = "qa" }, []string{"env must be"}},
+		{"mock in production", func(c *Config) { c.Env = EnvProduction; c.Gateway.Provider = "mock" }, []string{"mock is not allowed"}},
+		{"mock in staging", func(c *Config) { c.Env = EnvStaging; c.Gateway.Provider = "mock" }, nil},
+		{"wildcard origin in production", func(c *Config) {
+			c.Env = EnvProduction
+			c.Server.CORSOrigins = []string{"https://shop.example.com", "*"}
+		}, []string{"server.cors_origins"}},
+		{"wildcard origin in staging", func(c *Config) { c.Env = EnvStaging; c.Server.CORSOrigins = []string{"*"} }, nil},
+		{"relative url", func(c *Config) { c.Upstream.CheckURL = "/check" }, []string{"upstream.check_url"}},
+		{"uid too short", func(c *Config) { c.Pages.UIDLength = 4 }, []string{"pages.uid_length"}},
+		{"zero duration", func(c *Config) { c.Workers.WebhookInterval = 0 }, []string{"workers.webhook_interval"}},
+		{"reconcile within gateway timeout", func(c *Config) { c.Workers.ReconcileAfter = c.Gateway.Timeout }, []string{"workers.reconcile_after"}},
+		{
+			"reports every problem",
+			func(c *Config) { c.DatabaseURL = ""; c.Server.Addr = ""; c.Gateway.Timeout = -time.Second },
+			[]string{"database.url", "server.addr", "gateway.timeout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want errors about %q", tt.want)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("Validate() = %v, want it to mention %q", err, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "sections and types",
+			content: `env = "staging" # trailing comment
+
+[server]
+addr = ":9090"
+cors_origins = ["https://a.example.com", "https://b.example.com"]
+
+[upstream]
+ready_check = true
+
+[pages]
+uid_length = 12
+`,
+			want: map[string]string{
+				"env":                  "staging",
+				"server.addr":          ":9090",
+				"server.cors_origins":  "https://a.example.com,https://b.example.com",
+				"upstream.ready_check": "true",
+				"pages.uid_length":     "12",
+			},
+		},
+		{
+			name:    "hash inside a string",
+			content: `database.url = "postgres://u:p#w@db/x" # real comment`,
+			want:    map[string]string{"database.url": "postgres://u:p#w@db/x"},
+		},
+		{
+			name:    "escaped quote",
+			content: `title = "say \"hi\", # not a comment"`,
+			want:    map[string]string{"title": `say "hi", # not a comment`},
+		},
+		{
+			name:    "empty array",
+			content: `list = []`,
+			want:    map[string]string{"list": ""},
+		},
+		{name: "unquoted string", content: `env = staging`, wantErr: "strings must be quoted"},
+		{name: "missing equals", content: `env "staging"`, wantErr: "expected key = value"},
+		{name: "bad section", content: `[server`, wantErr: "malformed section header"},
+		{name: "unterminated array", content: `x = ["a"`, wantErr: "unterminated array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFile(writeConfig(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readFile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFileReportsLine(t *testing.T) {
+	_, err := readFile(writeConfig(t, "env = \"local\"\n\n[server]\naddr = 8080x\n"))
+	if err == nil || !strings.Contains(err.Error(), ":4: server.addr") {
+		t.Errorf("error = %v, want line 4 and key", err)
+	}
+}
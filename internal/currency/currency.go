@@ -0,0 +1,135 @@
+package currency
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency. Amounts are handled in minor units
+// (cents for USD); Exponent is how many of those make up one major unit as a
+// power of ten.
+type Currency struct {
+	Code     string
+	Exponent int
+	Symbol   string
+}
+
+// exponents lists active ISO 4217 codes whose minor unit is not 2 decimals.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// twoDecimal lists the remaining active ISO 4217 codes.
+var twoDecimal = strings.Fields(`
+	AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BMD BND BOB BOV BRL BSD BTN BWP BYN BZD
+	CAD CDF CHE CHF CHW CNY COP COU CRC CUP CVE CZK DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS
+	GIP GMD GTQ GYD HKD HNL HTG HUF IDR ILS INR IRR JMD KES KGS KHR KPW KYD KZT LAK LBP LKR LRD LSL
+	MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN NAD NGN NIO NOK NPR NZD PAB PEN PGK
+	PHP PKR PLN QAR RON RSD RUB SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS
+	TMT TOP TRY TTD TWD TZS UAH USD USN UYU UZS VES VED WST XCD YER ZAR ZMW ZWG
+`)
+
+var symbols = map[string]string{
+	"USD": "$", "CAD": "$", "AUD": "$", "NZD": "$", "MXN": "$", "HKD": "$", "SGD": "$",
+	"EUR": "€", "GBP": "£", "JPY": "¥", "CNY": "¥", "INR": "₹", "KRW": "₩", "BRL": "R$",
+	"ILS": "₪", "PHP": "₱", "NGN": "₦", "THB": "฿", "VND": "₫", "TRY": "₺", "UAH": "₴",
+	"PLN": "zł", "RUB": "₽",
+}
+
+var known = func() map[string]Currency {
+	m := make(map[string]Currency, len(twoDecimal)+len(exponents))
+	for _, code := range twoDecimal {
+		m[code] = Currency{Code: code, Exponent: 2, Symbol: symbols[code]}
+	}
+	for code, exp := range exponents {
+		m[code] = Currency{Code: code, Exponent: exp, Symbol: symbols[code]}
+	}
+	return m
+}()
+
+// Lookup finds a currency by its ISO 4217 code, ignoring case.
+func Lookup(code string) (Currency, bool) {
+	c, ok := known[strings.ToUpper(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// Or returns the currency for code, or USD when code is unknown. It is for
+// display and gateway paths where the code was validated on the way in.
+func Or(code string) Currency {
+	if c, ok := Lookup(code); ok {
+		return c
+	}
+	return known["USD"]
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// FormatDecimal renders minor units as a plain decimal, e.g. 1234 USD is
+// "12.34", 1234 JPY is "1234" and 1234 KWD is "1.234".
+func (c Currency) FormatDecimal(minor int64) string {
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	if c.Exponent == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	unit := pow10(c.Exponent)
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, c.Exponent, minor%unit)
+}
+
+// Format renders minor units for people, e.g. "USD $12.34" or "KWD 1.234".
+func (c Currency) Format(minor int64) string {
+	return c.Code + " " + c.Symbol + c.FormatDecimal(minor)
+}
+
+// ParseDecimal converts a decimal string such as "12.34" or "$1,050.5" into
+// minor units, rejecting more decimal places than the currency has.
+func (c Currency) ParseDecimal(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if c.Symbol != "" {
+		s = strings.TrimPrefix(s, c.Symbol)
+	}
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" {
+		return 0, nil
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > c.Exponent {
+		return 0, fmt.Errorf("invalid amount %q: more than %d decimal places for %s", s, c.Exponent, c.Code)
+	}
+	frac += strings.Repeat("0", c.Exponent-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	var f int64
+	if frac != "" {
+		f, err = strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	minor := w*pow10(c.Exponent) + f
+	if neg {
+		minor = -minor
+	}
+	return minor, nil
+}
@@ -0,0 +1,78 @@
+package currency
+
+import "testing"
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		code    string
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"USD", "12.34", 1234, false},
+		{"USD", "12.3", 1230, false},
+		{"USD", "12", 1200, false},
+		{"USD", ".5", 50, false},
+		{"USD", "$1,050.5", 105050, false},
+		{"USD", " -7.25 ", -725, false},
+		{"USD", "", 0, false},
+		{"USD", "12.345", 0, true},
+		{"USD", "12.3x", 0, true},
+		{"USD", "abc", 0, true},
+		{"JPY", "1500", 1500, false},
+		{"JPY", "1,500", 1500, false},
+		{"JPY", "1500.5", 0, true},
+		{"KWD", "1.234", 1234, false},
+		{"KWD", "1.2", 1200, false},
+		{"KWD", "1.2345", 0, true},
+	}
+	for _, tt := range tests {
+		c, ok := Lookup(tt.code)
+		if !ok {
+			t.Fatalf("Lookup(%q) failed", tt.code)
+		}
+		got, err := c.ParseDecimal(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s ParseDecimal(%q) error = %v, wantErr %v", tt.code, tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s ParseDecimal(%q) = %d, want %d", tt.code, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		code  string
+		minor int64
+		want  string
+	}{
+		{"USD", 1234, "12.34"},
+		{"USD", 5, "0.05"},
+		{"USD", 0, "0.00"},
+		{"USD", -1050, "-10.50"},
+		{"JPY", 1234, "1234"},
+		{"JPY", -3, "-3"},
+		{"KWD", 1234, "1.234"},
+		{"KWD", 7, "0.007"},
+	}
+	for _, tt := range tests {
+		c, _ := Lookup(tt.code)
+		if got := c.FormatDecimal(tt.minor); got != tt.want {
+			t.Errorf("%s FormatDecimal(%d) = %q, want %q", tt.code, tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDecimalRoundTrip(t *testing.T) {
+	for _, code := range []string{"USD", "JPY", "KWD", "EUR"} {
+		c, _ := Lookup(code)
+		for _, minor := range []int64{0, 1, 99, 100, 123456, -42} {
+			got, err := c.ParseDecimal(c.FormatDecimal(minor))
+			if err != nil || got != minor {
+				t.Errorf("%s round trip of %d = %d, %v", code, minor, got, err)
+			}
+		}
+	}
+}
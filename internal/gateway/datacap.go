@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"vitalink/internal/currency"
+)
+
+const DefaultDatacapBaseURL = "https://api.vitapay.com/v1/credit"
+
+// Datacap talks to the VitaPay proxy in front of Datacap's PayAPI.
+type Datacap struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewDatacap(baseURL string) *Datacap {
+	if baseURL == "" {
+		baseURL = DefaultDatacapBaseURL
+	}
+	return &Datacap{BaseURL: strings.TrimRight(baseURL, "/"), Client: http.DefaultClient}
+}
+
+func (d *Datacap) Sale(ctx context.Context, req SaleRequest) (*Result, error) {
+	return d.post(ctx, "/sale", req.Currency, salePayload(req))
+}
+
+func (d *Datacap) Authorize(ctx context.Context, req SaleRequest) (*Result, error) {
+	return d.post(ctx, "/preauth", req.Currency, salePayload(req))
+}
+
+func (d *Datacap) Capture(ctx context.Context, req CaptureRequest) (*Result, error) {
+	if req.RefNo == "" {
+		return nil, fmt.Errorf("capture: missing RefNo")
+	}
+	return d.post(ctx, "/preauthcapture/"+req.RefNo, req.Currency, map[string]string{
+		"Amount":     formatMinor(req.AmountCents, req.Currency),
+		"InvoiceNo":  req.InvoiceNo,
+		"MerchantID": req.MerchantID,
+		"PageUID":    req.PageUID,
+	})
+}
+
+func salePayload(req SaleRequest) map[string]string {
+	payload := map[string]string{
+		"Token":        req.Token,
+		"Amount":       formatMinor(req.AmountCents, req.Currency),
+		"Tax":          "",
+		"CustomerCode": req.InvoiceNo,
+		"PartialAuth":  "Disallow",
+		"CardHolderID": "Allow_V2",
+		"InvoiceNo":    req.InvoiceNo,
+		"MerchantID":   req.MerchantID,
+		"PageUID":      req.PageUID,
+	}
+	if req.TaxCents > 0 {
+		payload["Tax"] = formatMinor(req.TaxCents, req.Currency)
+	}
+	if req.PaymentFeeCents > 0 {
+		payload["PaymentFee"] = formatMinor(req.PaymentFeeCents, req.Currency)
+	}
+	if req.PaymentFeeDescription != "" {
+		payload["PaymentFeeDescription"] = req.PaymentFeeDescription
+	}
+	if req.SurchargeCents > 0 {
+		payload["SurchargeWithLookup"] = formatMinor(req.SurchargeCents, req.Currency)
+	}
+	return payload
+}
+
+func (d *Datacap) Void(ctx context.Context, req VoidRequest) (*Result, error) {
+	if req.RefNo == "" {
+		return nil, fmt.Errorf("void: missing RefNo")
+	}
+	return d.post(ctx, "/void/"+req.RefNo, "", map[string]string{
+		"InvoiceNo":  req.InvoiceNo,
+		"MerchantID": req.MerchantID,
+		"PageUID":    req.PageUID,
+	})
+}
+
+func (d *Datacap) Refund(ctx context.Context, req RefundRequest) (*Result, error) {
+	if req.RefNo == "" {
+		return nil, fmt.Errorf("refund: missing RefNo")
+	}
+	return d.post(ctx, "/return/"+req.RefNo, req.Currency, map[string]string{
+		"Amount":     formatMinor(req.AmountCents, req.Currency),
+		"InvoiceNo":  req.InvoiceNo,
+		"MerchantID": req.MerchantID,
+		"PageUID":    req.PageUID,
+	})
+}
+
+func (d *Datacap) Lookup(ctx context.Context, req LookupRequest) (*Result, error) {
+	return d.post(ctx, "/lookup", req.Currency, map[string]string{
+		"RefNo":      req.RefNo,
+		"InvoiceNo":  req.InvoiceNo,
+		"MerchantID": req.MerchantID,
+		"PageUID":    req.PageUID,
+	})
+}
+
+// post sends payload to path. currencyCode is used to read amounts in the
+// response back into minor units.
+func (d *Datacap) post(ctx context.Context, path, currencyCode string, payload map[string]string) (*Result, error) {
+	bodyBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.BaseURL+path, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("request build error: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("datacap request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBytes, _ := io.ReadAll(resp.Body)
+
+	return parseDatacapResponse(resp.StatusCode, respBytes, currencyCode), nil
+}
+
+func parseDatacapResponse(statusCode int, body []byte, currencyCode string) *Result {
+	var m map[string]any
+	_ = json.Unmarshal(body, &m)
+
+	res := &Result{HTTPStatus: statusCode, Raw: body}
+	res.Status = getString(m, "Status")
+	if res.Status == "" {
+		res.Status = getString(m, "CmdStatus")
+	}
+	res.Approved = strings.EqualFold(getString(m, "Status"), "Approved") ||
+		strings.EqualFold(getString(m, "CmdStatus"), "Approved")
+	if statusCode >= 400 {
+		res.Approved = false
+	}
+
+	res.Message = getString(m, "Message")
+	if res.Message == "" {
+		res.Message = getString(m, "TextResponse")
+	}
+	if res.Message == "" {
+		res.Message = strings.TrimSpace(string(body))
+	}
+
+	res.RefNo = getString(m, "RefNo")
+	res.AuthCode = getString(m, "AuthCode")
+	res.Last4 = getString(m, "Last4")
+	res.Brand = getString(m, "Brand")
+	if v := getString(m, "Authorize"); v != "" {
+		res.AuthorizedCents = parseMinor(v, currencyCode)
+	}
+	return res
+}
+
+func getString(m map[string]any, key string) string {
+	if v, ok := m[key]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// formatMinor renders an amount in minor units as the decimal string PayAPI
+// expects, using the currency's number of decimals.
+func formatMinor(minor int64, code string) string {
+	return currency.Or(code).FormatDecimal(minor)
+}
+
+func parseMinor(s, code string) int64 {
+	minor, err := currency.Or(code).ParseDecimal(s)
+	if err != nil {
+		return 0
+	}
+	return minor
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotSupported is returned by gateways that cannot perform an operation.
+var ErrNotSupported = errors.New("operation not supported by gateway")
+
+// PaymentGateway is a card processor that payment pages can be charged through.
+type PaymentGateway interface {
+	Sale(ctx context.Context, req SaleRequest) (*Result, error)
+	// Authorize places a hold for req.AmountCents that is later settled with
+	// Capture or released with Void.
+	Authorize(ctx context.Context, req SaleRequest) (*Result, error)
+	Capture(ctx context.Context, req CaptureRequest) (*Result, error)
+	Void(ctx context.Context, req VoidRequest) (*Result, error)
+	Refund(ctx context.Context, req RefundRequest) (*Result, error)
+	Lookup(ctx context.Context, req LookupRequest) (*Result, error)
+}
+
+// Resolver picks the gateway that processes payments for a merchant.
+type Resolver func(merchantID string) PaymentGateway
+
+// Single returns a Resolver that sends every merchant to the same gateway.
+func Single(g PaymentGateway) Resolver {
+	return func(string) PaymentGateway { return g }
+}
+
+type SaleRequest struct {
+	Token       string
+	AmountCents int64
+	Currency    string
+
+	MerchantID string
+	PageUID    string
+	InvoiceNo  string
+
+	TaxCents              int64
+	PaymentFeeCents       int64
+	PaymentFeeDescription string
+	SurchargeCents        int64
+}
+
+type CaptureRequest struct {
+	RefNo       string
+	AmountCents int64
+	Currency    string
+	MerchantID  string
+	PageUID     string
+	InvoiceNo   string
+}
+
+type VoidRequest struct {
+	RefNo      string
+	MerchantID string
+	PageUID    string
+	InvoiceNo  string
+}
+
+type RefundRequest struct {
+	RefNo       string
+	AmountCents int64
+	Currency    string
+	MerchantID  string
+	PageUID     string
+	InvoiceNo   string
+}
+
+type LookupRequest struct {
+	RefNo      string
+	Currency   string
+	MerchantID string
+	PageUID    string
+	InvoiceNo  string
+}
+
+// Result is the processor's answer to a request. A declined transaction is a
+// Result with Approved false, not an error; errors are reserved for requests
+// that never got an answer.
+type Result struct {
+	Approved bool   `json:"approved"`
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+
+	RefNo           string `json:"ref_no"`
+	AuthCode        string `json:"auth_code"`
+	AuthorizedCents int64  `json:"authorized_cents"`
+	Last4           string `json:"last4"`
+	Brand           string `json:"brand"`
+
+	// HTTPStatus is the status code returned by the processor, if any.
+	HTTPStatus int `json:"http_status"`
+	// Raw is the unparsed response body.
+	Raw []byte `json:"-"`
+}
+
+// Partial reports whether the processor approved less than was requested.
+func (r *Result) Partial(requestedCents int64) bool {
+	return r != nil && r.Approved && r.AuthorizedCents > 0 && r.AuthorizedCents < requestedCents
+}
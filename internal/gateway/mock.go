@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Magic tokens understood by Mock. Any other token falls back to the amount
+// based scenarios below.
+const (
+	MockTokenApprove = "mock-approve"
+	MockTokenDecline = "mock-decline"
+	MockTokenPartial = "mock-partial"
+	MockTokenError   = "mock-5xx"
+	MockTokenHang    = "mock-hang"
+)
+
+// Mock is an in-process gateway for local development and CI. Scenarios are
+// picked by the token or, failing that, by the last two digits of the amount:
+//
+//	xx.01  decline
+//	xx.02  partial approval (half the amount)
+//	xx.03  processor returns 502
+//	xx.04  hang until the request context is cancelled
+//
+// Everything else is approved.
+type Mock struct {
+	mu     sync.Mutex
+	seq    int
+	sales  map[string]*Result
+	byPage map[string]string
+}
+
+func NewMock() *Mock {
+	return &Mock{sales: map[string]*Result{}, byPage: map[string]string{}}
+}
+
+func mockScenario(token string, amountCents int64) string {
+	switch strings.ToLower(strings.TrimSpace(token)) {
+	case MockTokenApprove, MockTokenDecline, MockTokenPartial, MockTokenError, MockTokenHang:
+		return strings.ToLower(strings.TrimSpace(token))
+	}
+	switch amountCents % 100 {
+	case 1:
+		return MockTokenDecline
+	case 2:
+		return MockTokenPartial
+	case 3:
+		return MockTokenError
+	case 4:
+		return MockTokenHang
+	}
+	return MockTokenApprove
+}
+
+func (m *Mock) nextRef() string {
+	m.seq++
+	return fmt.Sprintf("MOCK%08d", m.seq)
+}
+
+func (m *Mock) Sale(ctx context.Context, req SaleRequest) (*Result, error) {
+	switch mockScenario(req.Token, req.AmountCents) {
+	case MockTokenDecline:
+		return &Result{Status: "Declined", Message: "DECLINED", HTTPStatus: http.StatusOK}, nil
+	case MockTokenError:
+		return &Result{Status: "Error", Message: "mock processor error", HTTPStatus: http.StatusBadGateway}, nil
+	case MockTokenHang:
+		<-ctx.Done()
+		return nil, fmt.Errorf("mock request failed: %w", ctx.Err())
+	case MockTokenPartial:
+		return m.approve(req, req.AmountCents/2), nil
+	}
+	return m.approve(req, req.AmountCents), nil
+}
+
+func (m *Mock) Authorize(ctx context.Context, req SaleRequest) (*Result, error) {
+	return m.Sale(ctx, req)
+}
+
+func (m *Mock) Capture(ctx context.Context, req CaptureRequest) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	auth, ok := m.sales[req.RefNo]
+	if !ok {
+		return &Result{Status: "Declined", Message: "unknown RefNo", HTTPStatus: http.StatusOK}, nil
+	}
+	if req.AmountCents > auth.AuthorizedCents {
+		return &Result{Status: "Declined", Message: "capture exceeds authorized amount", HTTPStatus: http.StatusOK}, nil
+	}
+	delete(m.sales, req.RefNo)
+	res := &Result{
+		Approved:        true,
+		Status:          "Approved",
+		Message:         "CAPTURED",
+		RefNo:           m.nextRef(),
+		AuthCode:        auth.AuthCode,
+		AuthorizedCents: req.AmountCents,
+		Last4:           auth.Last4,
+		Brand:           auth.Brand,
+		HTTPStatus:      http.StatusOK,
+	}
+	m.sales[res.RefNo] = res
+	m.byPage[req.MerchantID+"/"+req.PageUID] = res.RefNo
+	return res, nil
+}
+
+func (m *Mock) approve(req SaleRequest, authorized int64) *Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := &Result{
+		Approved:        true,
+		Status:          "Approved",
+		Message:         "APPROVED",
+		RefNo:           m.nextRef(),
+		AuthCode:        "MOCK01",
+		AuthorizedCents: authorized,
+		Last4:           "1111",
+		Brand:           "VISA",
+		HTTPStatus:      http.StatusOK,
+	}
+	m.sales[res.RefNo] = res
+	m.byPage[req.MerchantID+"/"+req.PageUID] = res.RefNo
+	return res
+}
+
+func (m *Mock) Void(ctx context.Context, req VoidRequest) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sales[req.RefNo]; !ok {
+		return &Result{Status: "Declined", Message: "unknown RefNo", HTTPStatus: http.StatusOK}, nil
+	}
+	delete(m.sales, req.RefNo)
+	return &Result{Approved: true, Status: "Approved", Message: "VOIDED", RefNo: m.nextRef(), HTTPStatus: http.StatusOK}, nil
+}
+
+func (m *Mock) Refund(ctx context.Context, req RefundRequest) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sale, ok := m.sales[req.RefNo]
+	if !ok {
+		return &Result{Status: "Declined", Message: "unknown RefNo", HTTPStatus: http.StatusOK}, nil
+	}
+	if req.AmountCents > sale.AuthorizedCents {
+		return &Result{Status: "Declined", Message: "refund exceeds sale amount", HTTPStatus: http.StatusOK}, nil
+	}
+	return &Result{
+		Approved:        true,
+		Status:          "Approved",
+		Message:         "REFUNDED",
+		RefNo:           m.nextRef(),
+		AuthorizedCents: req.AmountCents,
+		HTTPStatus:      http.StatusOK,
+	}, nil
+}
+
+func (m *Mock) Lookup(ctx context.Context, req LookupRequest) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ref := req.RefNo
+	if ref == "" {
+		ref = m.byPage[req.MerchantID+"/"+req.PageUID]
+	}
+	if sale, ok := m.sales[ref]; ok {
+		cp := *sale
+		return &cp, nil
+	}
+	return &Result{Status: "NotFound", Message: "no matching transaction", HTTPStatus: http.StatusNotFound}, nil
+}
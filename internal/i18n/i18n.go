@@ -0,0 +1,197 @@
+// Package i18n holds the message catalogs used to render customer-facing pages.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used when neither the page nor the browser asks for a
+// locale we have, and for keys missing from another catalog.
+const DefaultLocale = "en"
+
+// Catalog is the messages and number/date conventions for one locale.
+type Catalog struct {
+	Locale string `json:"-"`
+
+	Decimal    string            `json:"decimal"`
+	Group      string            `json:"group"`
+	DateLayout string            `json:"date_layout"`
+	Messages   map[string]string `json:"messages"`
+
+	fallback *Catalog
+}
+
+// Bundle is the set of catalogs loaded at startup.
+type Bundle struct {
+	catalogs map[string]*Catalog
+}
+
+// Load reads every <locale>.json file in dir of fsys. A catalog for
+// DefaultLocale is required.
+func Load(fsys fs.FS, dir string) (*Bundle, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{catalogs: map[string]*Catalog{}}
+	for _, f := range files {
+		raw, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		var cat Catalog
+		if err := json.Unmarshal(raw, &cat); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		cat.Locale = strings.ToLower(strings.TrimSuffix(path.Base(f), ".json"))
+		b.catalogs[cat.Locale] = &cat
+	}
+	def, ok := b.catalogs[DefaultLocale]
+	if !ok {
+		return nil, fmt.Errorf("i18n: no %s catalog in %s", DefaultLocale, dir)
+	}
+	for _, cat := range b.catalogs {
+		if cat != def {
+			cat.fallback = def
+		}
+		if cat.Decimal == "" {
+			cat.Decimal = "."
+		}
+		if cat.DateLayout == "" {
+			cat.DateLayout = "2006-01-02 15:04"
+		}
+	}
+	return b, nil
+}
+
+// Locales lists the loaded locales in sorted order.
+func (b *Bundle) Locales() []string {
+	out := make([]string, 0, len(b.catalogs))
+	for l := range b.catalogs {
+		out = append(out, l)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Catalog returns the catalog for locale, trying the base language when
+// the region is unknown ("es-MX" falls back to "es").
+func (b *Bundle) Catalog(locale string) (*Catalog, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if locale == "" {
+		return nil, false
+	}
+	if cat, ok := b.catalogs[locale]; ok {
+		return cat, true
+	}
+	base, _, _ := strings.Cut(locale, "-")
+	cat, ok := b.catalogs[base]
+	return cat, ok
+}
+
+// Match picks the catalog for a page: its own locale if set and known, then
+// the first known language in the Accept-Language header, then the default.
+func (b *Bundle) Match(pageLocale, acceptLanguage string) *Catalog {
+	if cat, ok := b.Catalog(pageLocale); ok {
+		return cat
+	}
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if cat, ok := b.Catalog(tag); ok {
+			return cat
+		}
+	}
+	return b.catalogs[DefaultLocale]
+}
+
+// parseAcceptLanguage returns the tags of an Accept-Language header ordered
+// by quality, dropping q=0 entries and the "*" wildcard.
+func parseAcceptLanguage(header string) []string {
+	type tagQ struct {
+		tag string
+		q   float64
+	}
+	var tags []tagQ
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tagQ{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.tag
+	}
+	return out
+}
+
+var localeTag = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// ValidTag reports whether s looks like a BCP 47 language tag such as "fr"
+// or "es-MX".
+func ValidTag(s string) bool {
+	return localeTag.MatchString(s)
+}
+
+// T returns the message for key, formatted with args when given. Keys missing
+// from the catalog come from the default locale, then the key itself.
+func (c *Catalog) T(key string, args ...any) string {
+	msg, ok := c.Messages[key]
+	if !ok && c.fallback != nil {
+		msg, ok = c.fallback.Messages[key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Number localizes a plain decimal such as "-1234.50" with the catalog's
+// decimal and grouping separators.
+func (c *Catalog) Number(plain string) string {
+	sign := ""
+	if strings.HasPrefix(plain, "-") {
+		sign, plain = "-", plain[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(plain, ".")
+	if c.Group != "" {
+		var b strings.Builder
+		for i, r := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(c.Group)
+			}
+			b.WriteRune(r)
+		}
+		whole = b.String()
+	}
+	if hasFrac {
+		return sign + whole + c.Decimal + frac
+	}
+	return sign + whole
+}
+
+// Date formats t with the catalog's layout.
+func (c *Catalog) Date(t time.Time) string {
+	return t.Format(c.DateLayout)
+}
@@ -0,0 +1,27 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"fr", []string{"fr"}},
+		{"fr-CA,fr;q=0.9,en;q=0.8", []string{"fr-CA", "fr", "en"}},
+		{"en;q=0.5, es;q=0.9, de", []string{"de", "es", "en"}},
+		{"es, en;q=0, *;q=0.1", []string{"es"}},
+		{"pt-BR;q=0.7,pt;q=0.7", []string{"pt-BR", "pt"}},
+		{"it;q=bogus", []string{"it"}},
+		{" , ;q=0.5", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
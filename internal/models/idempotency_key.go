@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// IdempotencyKey remembers the response given to a charge request so a retried
+// request with the same Idempotency-Key header replays it instead of charging again.
+type IdempotencyKey struct {
+	MerchantID   string `gorm:"primaryKey" json:"merchant_id"`
+	PageUID      string `gorm:"primaryKey" json:"page_uid"`
+	Key          string `gorm:"primaryKey" json:"key"`
+	RequestHash  string `json:"request_hash"`
+	Completed    bool   `json:"completed"`
+	StatusCode   int    `json:"status_code"`
+	ResponseBody string `gorm:"type:text" json:"response_body"`
+	// TransactionID is the charge attempt the request started, so a request
+	// that ended without a final answer can be answered from the ledger.
+	TransactionID *uint `json:"transaction_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
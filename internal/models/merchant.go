@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+)
+
+type Merchant struct {
+	MerchantID string `gorm:"primaryKey" json:"merchant_id"`
+	Name       string `json:"name"`
+	Branding
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Branding is how a merchant's hosted pages look. The logo fields are
+// defaults that a page's own Logo, Logo2, FavIcon and FeatureGraphic replace.
+type Branding struct {
+	Logo           string `json:"logo"`
+	Logo2          string `json:"logo2"`
+	FavIcon        string `json:"favicon"`
+	FeatureGraphic string `json:"feature_graphic"`
+
+	PrimaryColor string `json:"primary_color"`
+	AccentColor  string `json:"accent_color"`
+	FontFamily   string `json:"font_family"`
+
+	SupportEmail string `json:"support_email"`
+	SupportPhone string `json:"support_phone"`
+	FooterText   string `json:"footer_text"`
+}
+
+// ThemeFor returns the branding to render page with: the merchant's, with
+// any assets the page sets itself taking precedence. m may be nil.
+func ThemeFor(page *PaymentPage, m *Merchant) Branding {
+	var b Branding
+	if m != nil {
+		b = m.Branding
+	}
+	if page == nil {
+		return b
+	}
+	if page.Logo != "" {
+		b.Logo = page.Logo
+	}
+	if page.Logo2 != "" {
+		b.Logo2 = page.Logo2
+	}
+	if page.FavIcon != "" {
+		b.FavIcon = page.FavIcon
+	}
+	if page.FeatureGraphic != "" {
+		b.FeatureGraphic = page.FeatureGraphic
+	}
+	return b
+}
+
+// APIKey authenticates a merchant's server-to-server calls. Only a hash of the
+// key is stored; Prefix is kept in the clear so a key can be found and
+// recognised without revealing it.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	MerchantID string     `gorm:"index" json:"merchant_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"uniqueIndex" json:"prefix"`
+	Hash       string     `json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
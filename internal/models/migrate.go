@@ -0,0 +1,115 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// legacyMoneyColumns maps the decimal-string columns used before amounts were
+// typed to the cent columns that replace them.
+var legacyMoneyColumns = []struct{ from, to string }{
+	{"payment_fee_amount", "payment_fee_cents"},
+	{"surcharge_amount", "surcharge_cents"},
+	{"tax_amount", "tax_cents"},
+}
+
+// MigrateLegacyMoney copies the legacy fee, surcharge and tax strings into the
+// *_cents columns, rounds item amounts to whole cents, and drops the legacy
+// columns. It is a no-op once they are gone, so it is safe to run on every
+// start after AutoMigrate. If any amount cannot be parsed nothing is changed
+// and the offending pages are listed in the error, so they can be fixed by
+// hand before the legacy columns go.
+func MigrateLegacyMoney(db *gorm.DB) error {
+	m := db.Migrator()
+	var present []struct{ from, to string }
+	for _, c := range legacyMoneyColumns {
+		if m.HasColumn(&PaymentPage{}, c.from) {
+			present = append(present, c)
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var rows []map[string]any
+		if err := tx.Table("payment_pages").Find(&rows).Error; err != nil {
+			return fmt.Errorf("load payment pages: %w", err)
+		}
+
+		var unparseable []string
+		for _, row := range rows {
+			// Each page's amounts are in its own currency's minor units.
+			code, _ := row["currency"].(string)
+			updates := map[string]any{}
+			for _, c := range present {
+				s, _ := row[c.from].(string)
+				if s == "" {
+					continue
+				}
+				cents, err := ParseMoney(s, code)
+				if err != nil {
+					unparseable = append(unparseable, fmt.Sprintf("%v/%v %s=%q", row["merchant_id"], row["page_uid"], c.from, s))
+					continue
+				}
+				updates[c.to] = cents
+			}
+			if items, ok := row["items"].(string); ok && items != "" {
+				if fixed, changed := roundLegacyItems(items); changed {
+					updates["items"] = fixed
+				}
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := tx.Table("payment_pages").
+				Where("merchant_id = ? AND page_uid = ?", row["merchant_id"], row["page_uid"]).
+				Updates(updates).Error; err != nil {
+				return fmt.Errorf("migrate payment page %v/%v: %w", row["merchant_id"], row["page_uid"], err)
+			}
+		}
+
+		if len(unparseable) > 0 {
+			return fmt.Errorf("%d legacy amounts could not be parsed, fix them and restart: %s",
+				len(unparseable), strings.Join(unparseable, "; "))
+		}
+
+		for _, c := range present {
+			if err := tx.Migrator().DropColumn(&PaymentPage{}, c.from); err != nil {
+				return fmt.Errorf("drop %s: %w", c.from, err)
+			}
+		}
+		log.Println("Migrated legacy money columns on", len(rows), "payment pages")
+		return nil
+	})
+}
+
+// roundLegacyItems rounds fractional price and total values to whole cents.
+func roundLegacyItems(raw string) (string, bool) {
+	var items []map[string]any
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return raw, false
+	}
+	changed := false
+	for _, it := range items {
+		for _, k := range []string{"price", "total"} {
+			if f, ok := it[k].(float64); ok && f != math.Round(f) {
+				it[k] = math.Round(f)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return raw, false
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return raw, false
+	}
+	return string(b), true
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"vitalink/internal/currency"
+)
+
+// LineItem is one entry of PaymentPage.Items. Price and Total are in cents;
+// the JSON names predate the typed model and are what payment.html reads.
+type LineItem struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	PriceCents  int64  `json:"price"`
+	Quantity    int    `json:"quantity"`
+	TotalCents  int64  `json:"total"`
+}
+
+// LineTotalCents is the item's total, falling back to price × quantity (one if unset).
+func (it LineItem) LineTotalCents() int64 {
+	if it.TotalCents != 0 {
+		return it.TotalCents
+	}
+	q := int64(it.Quantity)
+	if q == 0 {
+		q = 1
+	}
+	return it.PriceCents * q
+}
+
+// ParseItems decodes a JSON item array. Amounts must be whole cents; numbers
+// like 1250.0 written by older clients are accepted, 12.5 is not.
+func ParseItems(raw []byte) ([]LineItem, error) {
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return nil, nil
+	}
+	var loose []struct {
+		Title       string  `json:"title"`
+		Description string  `json:"description"`
+		Price       float64 `json:"price"`
+		Quantity    int     `json:"quantity"`
+		Total       float64 `json:"total"`
+	}
+	if err := json.Unmarshal(raw, &loose); err != nil {
+		return nil, errors.New("items must be a JSON array of {title, description, price}")
+	}
+	items := make([]LineItem, len(loose))
+	for i, it := range loose {
+		if it.Price != math.Trunc(it.Price) || it.Total != math.Trunc(it.Total) {
+			return nil, fmt.Errorf("items[%d] price and total must be whole cents", i)
+		}
+		items[i] = LineItem{
+			Title:       it.Title,
+			Description: it.Description,
+			PriceCents:  int64(it.Price),
+			Quantity:    it.Quantity,
+			TotalCents:  int64(it.Total),
+		}
+	}
+	return items, nil
+}
+
+// ItemsTotalCents sums the line totals of the page's items.
+func (p *PaymentPage) ItemsTotalCents() (int64, error) {
+	items, err := ParseItems([]byte(p.Items))
+	if err != nil {
+		return 0, err
+	}
+	var sum int64
+	for _, it := range items {
+		sum += it.LineTotalCents()
+	}
+	return sum, nil
+}
+
+// ExtrasCents is tax, fee and surcharge together.
+func (p *PaymentPage) ExtrasCents() int64 {
+	return p.TaxCents + p.PaymentFeeCents + p.SurchargeCents
+}
+
+// GrandTotalCents is what the customer is charged: AmountCents, which already
+// holds items, tax, fee and surcharge, plus the tip.
+func (p *PaymentPage) GrandTotalCents(tipCents int64) int64 {
+	return p.AmountCents + tipCents
+}
+
+// ParseMoney converts a decimal string such as "12.34" or "$1,050.5" in the
+// currency code into minor units, USD if code is empty or unknown. It exists
+// for the string amounts stored before fields were typed.
+func ParseMoney(s, code string) (int64, error) {
+	return currency.Or(code).ParseDecimal(s)
+}
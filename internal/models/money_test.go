@@ -0,0 +1,28 @@
+package models
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in, code string
+		want     int64
+		wantErr  bool
+	}{
+		{"12.34", "USD", 1234, false},
+		{"$1,050.5", "USD", 105050, false},
+		{"", "USD", 0, false},
+		{"-3", "USD", -300, false},
+		{"1.234", "USD", 0, true},
+		{"abc", "USD", 0, true},
+		{"12.34", "", 1234, false},
+		{"1500", "JPY", 1500, false},
+		{"1500.5", "JPY", 0, true},
+		{"1.234", "KWD", 1234, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in, tt.code)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %d, %v; want %d, error %v", tt.in, tt.code, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -4,25 +4,35 @@ import (
 	"time"
 )
 
+const (
+	// CaptureAutomatic charges the card at checkout.
+	CaptureAutomatic = "automatic"
+	// CaptureManual only authorizes at checkout; the merchant captures later.
+	CaptureManual = "manual"
+)
+
 type PaymentPage struct {
 	MerchantID  string     `gorm:"primaryKey" json:"merchant_id"`
 	PageUID     string     `gorm:"primaryKey" json:"page_uid"`
 	RvcID       string     `json:"rvc_id"`
 	AmountCents int64      `json:"amount_cents"`
 	Currency    string     `gorm:"default:USD" json:"currency"`
+	Locale      string     `json:"locale"` // empty: use the customer's Accept-Language
 	Title       string     `json:"title"`
 	Description string     `json:"description"`
 	StoreName   string     `json:"store_name"`
-	Status      string     `gorm:"index" json:"status"`
+	Status      PageStatus `gorm:"index" json:"status"`
 	ExpireAt    *time.Time `json:"expire_at"`
+	CaptureMode string     `gorm:"default:automatic" json:"capture_mode"`
 
 	InvoiceNo             string `json:"invoice_no"`
 	IncludeTip            bool   `json:"include_tip"`
 	AllowedTipPercentages string `gorm:"type:text" json:"allowed_tip_percentages" default:"[15,18,20]"`
-	PaymentFeeAmount      string `json:"payment_fee_amount"`
+	MaxTipCents           int64  `json:"max_tip_cents"`
+	PaymentFeeCents       int64  `json:"payment_fee_cents"`
 	PaymentFeeDescription string `json:"payment_fee_description"`
-	SurchargeAmount       string `json:"surcharge_amount"`
-	TaxAmount             string `json:"tax_amount"`
+	SurchargeCents        int64  `json:"surcharge_cents"`
+	TaxCents              int64  `json:"tax_cents"`
 	Items                 string `gorm:"type:text" json:"items" default:"[]"`
 
 	PublicToken         string `json:"public_token"`
@@ -34,8 +44,12 @@ type PaymentPage struct {
 	Logo2               string `json:"logo2"`
 	FavIcon             string `json:"favicon"`
 
-	Last4 string `json:"last4" default:""`
-	Brand string `json:"brand" default:""`
+	Last4         string `json:"last4" default:""`
+	Brand         string `json:"brand" default:""`
+	RefundedCents int64  `json:"refunded_cents"`
+
+	AuthorizedAt *time.Time `gorm:"index" json:"authorized_at"`
+	CapturedAt   *time.Time `json:"captured_at"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	// ExceptionMissingInLedger is a settled row with no approved transaction.
+	ExceptionMissingInLedger = "missing_in_ledger"
+	// ExceptionMissingInSettlement is an approved transaction in the file's
+	// date range that the processor did not settle.
+	ExceptionMissingInSettlement = "missing_in_settlement"
+	// ExceptionDuplicate is a row for a transaction another row already settled.
+	ExceptionDuplicate = "duplicate"
+	// ExceptionAmountMismatch is a row that settled a different amount than
+	// was recorded.
+	ExceptionAmountMismatch = "amount_mismatch"
+	// ExceptionCurrencyMismatch is a row that settled in a different currency
+	// than was recorded.
+	ExceptionCurrencyMismatch = "currency_mismatch"
+	// ExceptionInvalidRow is a row that could not be read.
+	ExceptionInvalidRow = "invalid_row"
+)
+
+// SettlementRun is one processor settlement file checked against the ledger.
+type SettlementRun struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	MerchantID string `gorm:"index" json:"merchant_id"`
+	Filename   string `json:"filename"`
+	Rows       int    `json:"rows"`
+	Matched    int    `json:"matched"`
+	Exceptions int    `json:"exceptions"`
+	// From and To bound the settlement dates in the file, when it has them.
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SettlementException is one discrepancy found by a SettlementRun. Row is the
+// 1-based line in the file, or 0 for transactions the file left out.
+type SettlementException struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	RunID         uint   `gorm:"index" json:"run_id"`
+	Kind          string `gorm:"index" json:"kind"`
+	Row           int    `json:"row"`
+	InvoiceNo     string `json:"invoice_no"`
+	RefNo         string `json:"ref_no"`
+	Currency      string `json:"currency"`
+	SettledCents  int64  `json:"settled_cents"`
+	RecordedCents int64  `json:"recorded_cents"`
+	TransactionID *uint  `json:"transaction_id"`
+	PageUID       string `json:"page_uid"`
+	Detail        string `json:"detail"`
+}
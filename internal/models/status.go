@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+type PageStatus string
+
+const (
+	StatusOpen              PageStatus = "open"
+	StatusProcessing        PageStatus = "processing"
+	StatusAuthorized        PageStatus = "authorized"
+	StatusPaid              PageStatus = "paid"
+	StatusPartiallyRefunded PageStatus = "partially_refunded"
+	StatusRefunded          PageStatus = "refunded"
+	StatusVoided            PageStatus = "voided"
+	StatusCancelled         PageStatus = "cancelled"
+	StatusExpired           PageStatus = "expired"
+)
+
+// pageTransitions lists every status a page may move to from a given status.
+// Statuses missing from the map are final.
+var pageTransitions = map[PageStatus][]PageStatus{
+	StatusOpen:              {StatusProcessing, StatusCancelled, StatusExpired},
+	StatusProcessing:        {StatusOpen, StatusPaid, StatusAuthorized},
+	StatusAuthorized:        {StatusPaid, StatusVoided},
+	StatusPaid:              {StatusPartiallyRefunded, StatusRefunded, StatusVoided},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+}
+
+// ErrStatusConflict means the page changed status between being read and written.
+var ErrStatusConflict = errors.New("payment page status changed concurrently")
+
+type TransitionError struct {
+	From PageStatus
+	To   PageStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment page cannot move from %q to %q", e.From, e.To)
+}
+
+func (s PageStatus) CanTransitionTo(next PageStatus) bool {
+	for _, allowed := range pageTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the page to next, writing fields alongside the status. The
+// update only applies while the row still holds the status that was read, so
+// a concurrent writer gets ErrStatusConflict rather than a silent overwrite.
+// All status changes go through here.
+func (p *PaymentPage) Transition(db *gorm.DB, next PageStatus, fields map[string]any) error {
+	if !p.Status.CanTransitionTo(next) {
+		err := &TransitionError{From: p.Status, To: next}
+		log.Println("Rejected payment page transition:", p.MerchantID, p.PageUID, err)
+		return err
+	}
+
+	updates := map[string]any{"status": next}
+	for k, v := range fields {
+		updates[k] = v
+	}
+	res := db.Model(&PaymentPage{}).
+		Where("merchant_id = ? AND page_uid = ? AND status = ?", p.MerchantID, p.PageUID, p.Status).
+		Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		log.Println("Rejected payment page transition:", p.MerchantID, p.PageUID, p.Status, "->", next, ErrStatusConflict)
+		return ErrStatusConflict
+	}
+	p.Status = next
+	return nil
+}
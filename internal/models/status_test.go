@@ -0,0 +1,39 @@
+package models
+
+import "testing"
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to PageStatus
+		want     bool
+	}{
+		{StatusOpen, StatusProcessing, true},
+		{StatusOpen, StatusCancelled, true},
+		{StatusOpen, StatusExpired, true},
+		{StatusOpen, StatusPaid, false},
+		{StatusProcessing, StatusOpen, true},
+		{StatusProcessing, StatusPaid, true},
+		{StatusProcessing, StatusAuthorized, true},
+		{StatusProcessing, StatusCancelled, false},
+		{StatusAuthorized, StatusPaid, true},
+		{StatusAuthorized, StatusVoided, true},
+		{StatusAuthorized, StatusRefunded, false},
+		{StatusPaid, StatusPartiallyRefunded, true},
+		{StatusPaid, StatusRefunded, true},
+		{StatusPaid, StatusVoided, true},
+		{StatusPaid, StatusOpen, false},
+		{StatusPartiallyRefunded, StatusPartiallyRefunded, true},
+		{StatusPartiallyRefunded, StatusRefunded, true},
+		{StatusPartiallyRefunded, StatusVoided, false},
+		{StatusRefunded, StatusPartiallyRefunded, false},
+		{StatusVoided, StatusPaid, false},
+		{StatusCancelled, StatusOpen, false},
+		{StatusExpired, StatusOpen, false},
+		{"bogus", StatusOpen, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	TransactionSale          = "sale"
+	TransactionAuthorization = "authorization"
+	TransactionCapture       = "capture"
+	TransactionVoid          = "void"
+	TransactionRefund        = "refund"
+)
+
+const (
+	// TransactionPending is written before the gateway is called and replaced
+	// with the outcome once it answers. One left behind means the answer was
+	// lost; the reconciler asks the gateway what happened.
+	TransactionPending  = "pending"
+	TransactionApproved = "approved"
+	TransactionPartial  = "partial"
+	TransactionDeclined = "declined"
+	TransactionError    = "error"
+	// TransactionVoided is a charge the gateway approved that was then
+	// released, so no money moved. Only the reconciler sets it, on attempts
+	// whose answer arrived after the page had stopped waiting for them.
+	TransactionVoided = "voided"
+)
+
+// Transaction is one attempt against the payment gateway for a page. Every
+// attempt is kept, including declines and requests that never got an answer.
+type Transaction struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	MerchantID  string `gorm:"index:idx_transactions_page" json:"merchant_id"`
+	PageUID     string `gorm:"index:idx_transactions_page" json:"page_uid"`
+	Kind        string `gorm:"index" json:"kind"`
+	Status      string `gorm:"index" json:"status"`
+	AmountCents int64  `json:"amount_cents"`
+	// TipAmountCents is the part of AmountCents the customer added as a tip.
+	TipAmountCents int64  `json:"tip_amount_cents"`
+	Currency       string `json:"currency"`
+
+	GatewayStatus   string `json:"gateway_status"`
+	RefNo           string `gorm:"index" json:"ref_no"`
+	AuthCode        string `json:"auth_code"`
+	AuthorizedCents int64  `json:"authorized_cents"`
+	Last4           string `json:"last4"`
+	Brand           string `json:"brand"`
+	Message         string `json:"message"`
+	HTTPStatus      int    `json:"http_status"`
+	RawResponse     string `gorm:"type:text" json:"raw_response"`
+	Error           string `json:"error"`
+
+	// ReconciledAt is set when the reconciler, not the request, settled the
+	// attempt. Discrepancy describes what it found that needs a human.
+	ReconciledAt *time.Time `json:"reconciled_at,omitempty"`
+	Discrepancy  string     `gorm:"type:text" json:"discrepancy,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
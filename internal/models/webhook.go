@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookFailed    = "failed"
+)
+
+// WebhookEndpoint is a merchant URL that receives signed lifecycle events.
+// Events is a comma separated list of event types; empty means all events.
+type WebhookEndpoint struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	MerchantID string `gorm:"index" json:"merchant_id"`
+	URL        string `json:"url"`
+	Secret     string `json:"-"`
+	Events     string `gorm:"type:text" json:"events"`
+	Active     bool   `gorm:"default:true" json:"active"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for one endpoint. It stays pending until
+// the endpoint answers 2xx or the retry budget runs out.
+type WebhookDelivery struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	EndpointID    uint      `gorm:"index" json:"endpoint_id"`
+	MerchantID    string    `gorm:"index" json:"merchant_id"`
+	EventID       string    `gorm:"index" json:"event_id"`
+	EventType     string    `json:"event_type"`
+	Payload       string    `gorm:"type:text" json:"payload"`
+	Status        string    `gorm:"index:idx_webhook_deliveries_due" json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `gorm:"index:idx_webhook_deliveries_due" json:"next_attempt_at"`
+	LastHTTPCode  int       `json:"last_http_code"`
+	LastError     string    `json:"last_error"`
+
+	DeliveredAt *time.Time `json:"delivered_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// WebhookAttempt logs a single HTTP call made for a delivery.
+type WebhookAttempt struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	DeliveryID uint   `gorm:"index" json:"delivery_id"`
+	HTTPStatus int    `json:"http_status"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"duration_ms"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+// Package netguard keeps requests to merchant-supplied URLs, such as logos
+// and webhook endpoints, away from this host and the internal network.
+package netguard
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// PublicOnly is a net.Dialer Control that refuses any address that is not
+// public. It runs after DNS resolution, on the address actually dialed, so a
+// hostname cannot be pointed at a private address to get past it.
+func PublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%s is not a public address", ip)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package qr
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// Image is a rendered code ready to serve.
+type Image struct {
+	Body        []byte
+	ContentType string
+	ETag        string
+}
+
+// NewImage wraps body with a strong ETag derived from its contents.
+func NewImage(body []byte, contentType string) *Image {
+	sum := sha256.Sum256(body)
+	return &Image{Body: body, ContentType: contentType, ETag: fmt.Sprintf(`"%x"`, sum[:16])}
+}
+
+// Cache is a fixed-size LRU of rendered codes, safe for concurrent use.
+type Cache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key string
+	img *Image
+}
+
+func NewCache(maxEntries int) *Cache {
+	return &Cache{max: maxEntries, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *Cache) Get(key string) (*Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).img, true
+}
+
+func (c *Cache) Add(key string, img *Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).img = img
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, img: img})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
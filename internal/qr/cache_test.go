@@ -0,0 +1,53 @@
+package qr
+
+import "testing"
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2)
+	a, b, d := NewImage([]byte("a"), "image/png"), NewImage([]byte("b"), "image/png"), NewImage([]byte("d"), "image/png")
+
+	c.Add("a", a)
+	c.Add("b", b)
+	if _, ok := c.Get("a"); !ok { // a is now the most recently used
+		t.Fatal("a missing before eviction")
+	}
+	c.Add("d", d)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if got, ok := c.Get("a"); !ok || got != a {
+		t.Error("a should have been kept")
+	}
+	if got, ok := c.Get("d"); !ok || got != d {
+		t.Error("d should have been added")
+	}
+}
+
+func TestCacheAddReplaces(t *testing.T) {
+	c := NewCache(2)
+	old, fresh := NewImage([]byte("old"), "image/png"), NewImage([]byte("new"), "image/png")
+	c.Add("k", old)
+	c.Add("k", fresh)
+	c.Add("other", old)
+
+	if got, ok := c.Get("k"); !ok || got != fresh {
+		t.Errorf("Get(k) = %v, %v; want the replacement", got, ok)
+	}
+	if c.order.Len() != 2 {
+		t.Errorf("cache holds %d entries, want 2", c.order.Len())
+	}
+}
+
+func TestNewImageETag(t *testing.T) {
+	a1, a2, b := NewImage([]byte("a"), "image/png"), NewImage([]byte("a"), "image/svg+xml"), NewImage([]byte("b"), "image/png")
+	if a1.ETag != a2.ETag {
+		t.Errorf("same body gave ETags %s and %s", a1.ETag, a2.ETag)
+	}
+	if a1.ETag == b.ETag {
+		t.Errorf("different bodies share ETag %s", a1.ETag)
+	}
+	if len(a1.ETag) < 2 || a1.ETag[0] != '"' || a1.ETag[len(a1.ETag)-1] != '"' {
+		t.Errorf("ETag %s is not quoted", a1.ETag)
+	}
+}
@@ -0,0 +1,254 @@
+// Package qr renders payment page QR codes as PNG, SVG or a printable table
+// tent, with configurable colors, quiet zone and an optional center logo.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+	FormatPDF = "pdf"
+
+	LayoutCode = ""
+	LayoutTent = "tent"
+)
+
+// Options control how a code is drawn. The zero value is not useful; start
+// from DefaultOptions.
+type Options struct {
+	Level      qrcode.RecoveryLevel
+	Size       int // pixels for PNG/SVG codes
+	Foreground color.RGBA
+	Background color.RGBA
+	QuietZone  int // modules of blank border
+	Format     string
+	Layout     string
+
+	// Logo is drawn in the middle of the code, which needs high recovery
+	// to stay scannable, so setting it raises Level to qrcode.Highest.
+	Logo image.Image
+
+	// Title, Amount and Caption are printed on the table tent.
+	Title   string
+	Amount  string
+	Caption string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Level:      qrcode.Medium,
+		Size:       256,
+		Foreground: color.RGBA{0, 0, 0, 255},
+		Background: color.RGBA{255, 255, 255, 255},
+		QuietZone:  4,
+		Format:     FormatPNG,
+	}
+}
+
+// logoFraction is the share of the code's width the logo may cover.
+const logoFraction = 0.22
+
+// ParseLevel reads an error-correction level: L, M, Q or H.
+func ParseLevel(s string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("level must be one of L, M, Q, H")
+}
+
+// ParseColor reads a hex color with or without the leading '#'.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if len(s) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// Render draws content according to opts and returns the bytes with their
+// content type.
+func Render(content string, opts Options) ([]byte, string, error) {
+	if opts.Logo != nil {
+		opts.Level = qrcode.Highest
+	}
+	q, err := qrcode.New(content, opts.Level)
+	if err != nil {
+		return nil, "", err
+	}
+	q.DisableBorder = true
+	m := q.Bitmap()
+
+	if opts.Layout == LayoutTent {
+		switch opts.Format {
+		case FormatPDF:
+			return tentPDF(m, opts), "application/pdf", nil
+		case FormatSVG:
+			return []byte(tentSVG(m, opts)), "image/svg+xml", nil
+		}
+		return nil, "", errors.New("the tent layout is available as pdf or svg")
+	}
+	switch opts.Format {
+	case FormatPNG:
+		b, err := renderPNG(m, opts)
+		return b, "image/png", err
+	case FormatSVG:
+		return []byte(renderSVG(m, opts)), "image/svg+xml", nil
+	}
+	return nil, "", fmt.Errorf("format %q is not supported for a plain code", opts.Format)
+}
+
+func renderPNG(m [][]bool, opts Options) ([]byte, error) {
+	total := len(m) + 2*opts.QuietZone
+	px := opts.Size / total
+	if px < 1 {
+		px = 1
+	}
+	size := opts.Size
+	if size < px*total {
+		size = px * total
+	}
+	off := (size-px*total)/2 + opts.QuietZone*px
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+	fg := &image.Uniform{opts.Foreground}
+	for y, row := range m {
+		for x, on := range row {
+			if on {
+				r := image.Rect(off+x*px, off+y*px, off+(x+1)*px, off+(y+1)*px)
+				draw.Draw(img, r, fg, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		codePx := len(m) * px
+		box := int(float64(codePx) * logoFraction)
+		logo := fit(opts.Logo, box)
+		lb := logo.Bounds()
+		pad := px
+		x0 := off + (codePx-lb.Dx())/2
+		y0 := off + (codePx-lb.Dy())/2
+		bg := image.Rect(x0-pad, y0-pad, x0+lb.Dx()+pad, y0+lb.Dy()+pad)
+		draw.Draw(img, bg, &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+		draw.Draw(img, lb.Add(image.Pt(x0, y0)), logo, lb.Min, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(m [][]bool, opts Options) string {
+	total := len(m) + 2*opts.QuietZone
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		total, total, opts.Size, opts.Size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/>`, total, total, hex(opts.Background))
+	b.WriteString(svgCode(m, opts, float64(opts.QuietZone), float64(opts.QuietZone), 1))
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// svgCode draws the modules of m at (x, y) with each module unit wide,
+// followed by the logo if there is one.
+func svgCode(m [][]bool, opts Options, x, y, unit float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<path fill="%s" d="`, hex(opts.Foreground))
+	for row := range m {
+		for col := 0; col < len(m[row]); {
+			if !m[row][col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(m[row]) && m[row][col] {
+				col++
+			}
+			fmt.Fprintf(&b, "M%s %sh%sv%sh-%sz", num(x+float64(start)*unit), num(y+float64(row)*unit),
+				num(float64(col-start)*unit), num(unit), num(float64(col-start)*unit))
+		}
+	}
+	b.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		codeWidth := float64(len(m)) * unit
+		encoded, w, h := logoPNG(opts.Logo)
+		box := codeWidth * logoFraction
+		scale := box / float64(max(w, h))
+		lw, lh := float64(w)*scale, float64(h)*scale
+		lx, ly := x+(codeWidth-lw)/2, y+(codeWidth-lh)/2
+		fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`,
+			num(lx-unit), num(ly-unit), num(lw+2*unit), num(lh+2*unit), hex(opts.Background))
+		fmt.Fprintf(&b, `<image x="%s" y="%s" width="%s" height="%s" href="data:image/png;base64,%s"/>`,
+			num(lx), num(ly), num(lw), num(lh), encoded)
+	}
+	return b.String()
+}
+
+// fit scales img down (nearest neighbour) so its longer side is at most box.
+func fit(img image.Image, box int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= box && h <= box || w == 0 || h == 0 {
+		return img
+	}
+	nw, nh := box, h*box/w
+	if h > w {
+		nw, nh = w*box/h, box
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	out := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			out.Set(x, y, img.At(b.Min.X+x*w/nw, b.Min.Y+y*h/nh))
+		}
+	}
+	return out
+}
+
+func logoPNG(img image.Image) (string, int, int) {
+	img = fit(img, 256)
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), img.Bounds().Dx(), img.Bounds().Dy()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
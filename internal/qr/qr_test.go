@@ -0,0 +1,34 @@
+package qr
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{"#000000", color.RGBA{0, 0, 0, 255}, false},
+		{"ffffff", color.RGBA{255, 255, 255, 255}, false},
+		{"#7c3aed", color.RGBA{0x7c, 0x3a, 0xed, 255}, false},
+		{"#7C3AED", color.RGBA{0x7c, 0x3a, 0xed, 255}, false},
+		{"#abc", color.RGBA{0xaa, 0xbb, 0xcc, 255}, false},
+		{"", color.RGBA{}, true},
+		{"#abcd", color.RGBA{}, true},
+		{"#12345g", color.RGBA{}, true},
+		{"#1234567", color.RGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseColor(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
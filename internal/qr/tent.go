@@ -0,0 +1,275 @@
+package qr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// A table tent is a US Letter sheet folded in half across the middle. Each
+// half carries the same panel, the top one upside down, so both faces read
+// upright once folded. Panel coordinates run from the fold (y = 0) to the
+// table edge (y = tentPanelH), in points.
+const (
+	tentPageW  = 612.0
+	tentPageH  = 792.0
+	tentPanelH = tentPageH / 2
+
+	tentCodeSide   = 220.0
+	tentCodeTop    = 92.0
+	tentTitleY     = 62.0
+	tentAmountY    = 346.0
+	tentCaptionY   = 372.0
+	tentTitleSize  = 26.0
+	tentAmountSize = 20.0
+	tentCaptionPt  = 12.0
+	tentMaxText    = 540.0
+)
+
+func tentSVG(m [][]bool, opts Options) string {
+	total := len(m) + 2*opts.QuietZone
+	unit := tentCodeSide / float64(total)
+	codeX := (tentPageW - tentCodeSide) / 2
+
+	var panel strings.Builder
+	text := func(s string, y, size float64, weight string) {
+		if s == "" {
+			return
+		}
+		// Squeeze text that would run off the panel.
+		fit := ""
+		if helveticaWidth(s, size) > tentMaxText {
+			fit = fmt.Sprintf(` textLength="%s" lengthAdjust="spacingAndGlyphs"`, num(tentMaxText))
+		}
+		fmt.Fprintf(&panel, `<text x="%s" y="%s" font-size="%s" font-weight="%s" text-anchor="middle"%s>%s</text>`,
+			num(tentPageW/2), num(y), num(size), weight, fit, html.EscapeString(s))
+	}
+	text(opts.Title, tentTitleY, tentTitleSize, "bold")
+	fmt.Fprintf(&panel, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`,
+		num(codeX), num(tentCodeTop), num(tentCodeSide), num(tentCodeSide), hex(opts.Background))
+	panel.WriteString(svgCode(m, opts, codeX+float64(opts.QuietZone)*unit, tentCodeTop+float64(opts.QuietZone)*unit, unit))
+	text(opts.Amount, tentAmountY, tentAmountSize, "bold")
+	text(opts.Caption, tentCaptionY, tentCaptionPt, "normal")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %s %s" width="8.5in" height="11in" font-family="Helvetica, Arial, sans-serif">`,
+		num(tentPageW), num(tentPageH))
+	fmt.Fprintf(&b, `<rect width="%s" height="%s" fill="#ffffff"/>`, num(tentPageW), num(tentPageH))
+	fmt.Fprintf(&b, `<g transform="rotate(180 %s %s)">%s</g>`, num(tentPageW/2), num(tentPanelH/2), panel.String())
+	fmt.Fprintf(&b, `<g transform="translate(0 %s)">%s</g>`, num(tentPanelH), panel.String())
+	fmt.Fprintf(&b, `<line x1="36" y1="%s" x2="%s" y2="%s" stroke="#94a3b8" stroke-width="0.5" stroke-dasharray="6 4"/>`,
+		num(tentPanelH), num(tentPageW-36), num(tentPanelH))
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func tentPDF(m [][]bool, opts Options) []byte {
+	total := len(m) + 2*opts.QuietZone
+	unit := tentCodeSide / float64(total)
+	codeX := (tentPageW - tentCodeSide) / 2
+
+	var logo *pdfImage
+	if opts.Logo != nil {
+		logo = newPDFImage(fit(opts.Logo, 256), opts.Background)
+	}
+
+	// The panel is drawn for the bottom half, where panel y maps to
+	// tentPanelH - y; the top half reuses it under a 180 degree rotation.
+	var panel bytes.Buffer
+	text := func(s string, y, size float64) {
+		if s == "" {
+			return
+		}
+		if w := helveticaWidth(s, size); w > tentMaxText {
+			size *= tentMaxText / w
+		}
+		x := (tentPageW - helveticaWidth(s, size)) / 2
+		fmt.Fprintf(&panel, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", num(size), num(x), num(tentPanelH-y), pdfString(s))
+	}
+	panel.WriteString("0 0 0 rg\n")
+	text(opts.Title, tentTitleY, tentTitleSize)
+	text(opts.Amount, tentAmountY, tentAmountSize)
+	text(opts.Caption, tentCaptionY, tentCaptionPt)
+
+	fmt.Fprintf(&panel, "%s rg %s %s %s %s re f\n", pdfColor(opts.Background),
+		num(codeX), num(tentPanelH-tentCodeTop-tentCodeSide), num(tentCodeSide), num(tentCodeSide))
+	fmt.Fprintf(&panel, "%s rg\n", pdfColor(opts.Foreground))
+	top := tentCodeTop + float64(opts.QuietZone)*unit
+	left := codeX + float64(opts.QuietZone)*unit
+	for row := range m {
+		for col := 0; col < len(m[row]); {
+			if !m[row][col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(m[row]) && m[row][col] {
+				col++
+			}
+			fmt.Fprintf(&panel, "%s %s %s %s re\n", num(left+float64(start)*unit),
+				num(tentPanelH-top-float64(row+1)*unit), num(float64(col-start)*unit), num(unit))
+		}
+	}
+	panel.WriteString("f\n")
+
+	if logo != nil {
+		codeWidth := float64(len(m)) * unit
+		scale := codeWidth * logoFraction / float64(max(logo.w, logo.h))
+		lw, lh := float64(logo.w)*scale, float64(logo.h)*scale
+		lx := left + (codeWidth-lw)/2
+		ly := tentPanelH - top - codeWidth + (codeWidth-lh)/2
+		fmt.Fprintf(&panel, "%s rg %s %s %s %s re f\n", pdfColor(opts.Background),
+			num(lx-unit), num(ly-unit), num(lw+2*unit), num(lh+2*unit))
+		fmt.Fprintf(&panel, "q %s 0 0 %s %s %s cm /Im1 Do Q\n", num(lw), num(lh), num(lx), num(ly))
+	}
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "q -1 0 0 -1 %s %s cm\n", num(tentPageW), num(tentPageH))
+	content.Write(panel.Bytes())
+	content.WriteString("Q\n")
+	content.Write(panel.Bytes())
+	fmt.Fprintf(&content, "0.58 0.64 0.72 RG 0.5 w [6 4] 0 d 36 %s m %s %s l S\n",
+		num(tentPanelH), num(tentPageW-36), num(tentPanelH))
+
+	return writePDF(content.Bytes(), logo)
+}
+
+// pdfImage is an opaque RGB image XObject; transparency is flattened onto
+// the code's background color.
+type pdfImage struct {
+	w, h int
+	data []byte // zlib-compressed RGB
+}
+
+func newPDFImage(img image.Image, bg color.RGBA) *pdfImage {
+	b := img.Bounds()
+	raw := make([]byte, 0, b.Dx()*b.Dy()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := uint32(c.A)
+			blend := func(fg, back uint8) byte {
+				return byte((uint32(fg)*a + uint32(back)*(255-a)) / 255)
+			}
+			raw = append(raw, blend(c.R, bg.R), blend(c.G, bg.G), blend(c.B, bg.B))
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+	return &pdfImage{w: b.Dx(), h: b.Dy(), data: z.Bytes()}
+}
+
+func writePDF(content []byte, logo *pdfImage) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string, stream []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			out.WriteString("stream\n")
+			out.Write(stream)
+			out.WriteString("\nendstream\n")
+		}
+		out.WriteString("endobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	xobjects := ""
+	if logo != nil {
+		xobjects = "/XObject << /Im1 6 0 R >> "
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	obj("<< /Type /Pages /Kids [3 0 R] /Count 1 >>", nil)
+	obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 4 0 R >> %s>> /Contents 5 0 R >>",
+		num(tentPageW), num(tentPageH), xobjects), nil)
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	obj(fmt.Sprintf("<< /Length %d >>", len(content)), content)
+	if logo != nil {
+		obj(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>",
+			logo.w, logo.h, len(logo.data)), logo.data)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+func pdfColor(c color.RGBA) string {
+	return fmt.Sprintf("%s %s %s", num(float64(c.R)/255), num(float64(c.G)/255), num(float64(c.B)/255))
+}
+
+// pdfString encodes s for a WinAnsi font inside a PDF literal string.
+// Characters outside WinAnsi become '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := winAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+func winAnsi(r rune) (byte, bool) {
+	switch {
+	case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0xff:
+		return byte(r), true
+	case r == '€':
+		return 0x80, true
+	case r == '–':
+		return 0x96, true
+	case r == '—':
+		return 0x97, true
+	case r == '’':
+		return 0x92, true
+	case r == '•':
+		return 0x95, true
+	}
+	return 0, false
+}
+
+// helveticaWidths are the standard Helvetica advance widths for ' '..'~'
+// in thousandths of the font size.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556,
+	278, 278, 584, 584, 584, 556, 1015,
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611,
+	278, 278, 278, 469, 556, 333,
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500,
+	334, 260, 334, 584,
+}
+
+func helveticaWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += helveticaWidths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/auth"
+	"vitalink/internal/config"
+	"vitalink/internal/models"
+)
+
+const ctxMerchantID = "merchant_id"
+
+// apiKeyAuth authenticates the caller and binds the request to their merchant.
+// Keys issued here are checked locally; any other bearer token is resolved
+// through the VitaByte config service as before. Routes with a :merchant_id
+// param are only reachable by that merchant.
+func apiKeyAuth(cfg *config.Config, db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+			if token == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]any{"error": "missing API key"})
+			}
+
+			var merchantID string
+			if auth.IsAPIKey(token) {
+				key, err := auth.Authenticate(db, token)
+				if errors.Is(err, auth.ErrInvalidKey) {
+					return c.JSON(http.StatusUnauthorized, map[string]any{"error": "invalid API key"})
+				} else if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+				}
+				merchantID = key.MerchantID
+			} else {
+				id, err := grabConfig(cfg.Upstream, header)
+				if err != nil || id == "" {
+					log.Println("Config token rejected:", err)
+					return c.JSON(http.StatusUnauthorized, map[string]any{"error": "invalid API key"})
+				}
+				merchantID = id
+			}
+
+			if p := c.Param("merchant_id"); p != "" && p != merchantID {
+				return c.JSON(http.StatusForbidden, map[string]any{"error": "forbidden for this merchant"})
+			}
+			c.Set(ctxMerchantID, merchantID)
+			return next(c)
+		}
+	}
+}
+
+// authedMerchant returns the merchant the request was authenticated as.
+func authedMerchant(c echo.Context) string {
+	id, _ := c.Get(ctxMerchantID).(string)
+	return id
+}
+
+func handleCreateAPIKey(c echo.Context, db *gorm.DB) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+	full, key, err := auth.CreateKey(db, authedMerchant(c), req.Name)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "create failed", "details": err.Error()})
+	}
+	// The key is only ever shown here.
+	return c.JSON(http.StatusCreated, map[string]any{"api_key": full, "key": key})
+}
+
+func handleListAPIKeys(c echo.Context, db *gorm.DB) error {
+	var keys []models.APIKey
+	if err := db.Order("id ASC").Find(&keys, "merchant_id = ?", authedMerchant(c)).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": keys})
+}
+
+func handleRevokeAPIKey(c echo.Context, db *gorm.DB) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "api key not found"})
+	}
+	res := db.Model(&models.APIKey{}).
+		Where("id = ? AND merchant_id = ? AND revoked_at IS NULL", id, authedMerchant(c)).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	if res.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "api key not found"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
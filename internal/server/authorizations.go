@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+	"vitalink/internal/webhooks"
+)
+
+// openAuthorization returns the approved authorization holding funds for the page.
+func openAuthorization(db *gorm.DB, page *models.PaymentPage) (*models.Transaction, error) {
+	var auth models.Transaction
+	err := db.Order("id DESC").First(&auth,
+		"merchant_id = ? AND page_uid = ? AND kind = ? AND status = ?",
+		page.MerchantID, page.PageUID, models.TransactionAuthorization, models.TransactionApproved).Error
+	if err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+var (
+	errNoAuthorization    = errors.New("no open authorization found for payment page")
+	errAuthorizationBusy  = errors.New("a capture or void is in progress for this payment page")
+	errOutcomeNotRecorded = errors.New("gateway outcome could not be recorded, it stays pending until confirmed")
+)
+
+// holdAuthorization finds the open authorization on a page locked in tx and
+// writes a pending attempt of kind against it, so no other capture or void
+// can start until this one settles. amountCents defaults to the amount held.
+func holdAuthorization(tx *gorm.DB, page *models.PaymentPage, kind string, amountCents int64) (auth, txn *models.Transaction, err error) {
+	auth, err = openAuthorization(tx, page)
+	if err != nil {
+		return nil, nil, errNoAuthorization
+	}
+	busy, _, err := pendingAttempts(tx, page, models.TransactionCapture, models.TransactionVoid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if busy > 0 {
+		return nil, nil, errAuthorizationBusy
+	}
+	if amountCents == 0 {
+		amountCents = saleAmountCents(auth)
+	}
+	var tipCents int64
+	if kind == models.TransactionCapture {
+		tipCents = auth.TipAmountCents
+	}
+	txn, err = beginTransaction(tx, page, kind, amountCents, tipCents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return auth, txn, nil
+}
+
+// releaseAuthorization voids the hold auth placed, settling the pending void
+// txn and, if the gateway approves, moving the page to "voided" in the same
+// transaction. A timeout leaves the void pending.
+func releaseAuthorization(ctx context.Context, db *gorm.DB, gw gateway.PaymentGateway, page *models.PaymentPage, auth, txn *models.Transaction) (*gateway.Result, error) {
+	res, err := gw.Void(ctx, gateway.VoidRequest{
+		RefNo:      auth.RefNo,
+		MerchantID: page.MerchantID,
+		PageUID:    page.PageUID,
+		InvoiceNo:  page.InvoiceNo,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	_, serr := settleFollowUp(db, txn, res, err, func(tx *gorm.DB, page *models.PaymentPage) error {
+		return page.Transition(tx, models.StatusVoided, nil)
+	})
+	if serr != nil {
+		return res, fmt.Errorf("%w: %v", errOutcomeNotRecorded, serr)
+	}
+	return res, err
+}
+
+func handleCapturePayment(c echo.Context, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver) error {
+	var req struct {
+		AmountCents int64 `json:"amount_cents"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid request"})
+	}
+	if req.AmountCents < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "amount_cents must be >= 0"})
+	}
+
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	page, err := lockPaymentPage(tx, c.Param("merchant_id"), c.Param("page_uid"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if page.Status != models.StatusAuthorized {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "payment page is not authorized", "status": page.Status})
+	}
+
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "no payment gateway configured"})
+	}
+
+	// The capture goes on the ledger, and the page lock is released, before
+	// the gateway is called.
+	auth, txn, err := holdAuthorization(tx, page, models.TransactionCapture, req.AmountCents)
+	if errors.Is(err, errNoAuthorization) || errors.Is(err, errAuthorizationBusy) {
+		return c.JSON(http.StatusConflict, map[string]any{"error": err.Error()})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "could not record capture attempt", "details": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "transaction commit failed", "details": err.Error()})
+	}
+	committed = true
+
+	ctx, cancel := gatewayContext(c, cfg)
+	defer cancel()
+
+	res, err := gw.Capture(ctx, gateway.CaptureRequest{
+		RefNo:       auth.RefNo,
+		AmountCents: txn.AmountCents,
+		Currency:    page.Currency,
+		MerchantID:  page.MerchantID,
+		PageUID:     page.PageUID,
+		InvoiceNo:   page.InvoiceNo,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("Gateway timed out, leaving capture pending:", page.MerchantID, page.PageUID, txn.ID, err)
+		return c.JSON(http.StatusGatewayTimeout, map[string]any{"error": "capture outcome unknown, it stays pending until confirmed", "details": err.Error()})
+	}
+
+	_, serr := settleFollowUp(db, txn, res, err, func(tx *gorm.DB, page *models.PaymentPage) error {
+		if err := page.Transition(tx, models.StatusPaid, map[string]any{"captured_at": time.Now()}); err != nil {
+			return err
+		}
+		webhooks.Emit(tx, page.MerchantID, webhooks.EventPagePaid, page)
+		return nil
+	})
+	if serr != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": errOutcomeNotRecorded.Error(), "details": serr.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]any{"error": "capture request failed", "details": err.Error()})
+	}
+	if !res.Approved {
+		status := http.StatusBadRequest
+		if res.HTTPStatus >= 400 {
+			status = res.HTTPStatus
+		}
+		return c.JSON(status, map[string]any{"approved": false, "message": res.Message})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"approved":       true,
+		"message":        res.Message,
+		"status":         models.StatusPaid,
+		"captured_cents": txn.AmountCents,
+	})
+}
+
+func handleVoidAuthorization(c echo.Context, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver) error {
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	page, err := lockPaymentPage(tx, c.Param("merchant_id"), c.Param("page_uid"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if page.Status != models.StatusAuthorized {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "payment page is not authorized", "status": page.Status})
+	}
+
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "no payment gateway configured"})
+	}
+
+	auth, txn, err := holdAuthorization(tx, page, models.TransactionVoid, 0)
+	if errors.Is(err, errNoAuthorization) || errors.Is(err, errAuthorizationBusy) {
+		return c.JSON(http.StatusConflict, map[string]any{"error": err.Error()})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "could not record void attempt", "details": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "transaction commit failed", "details": err.Error()})
+	}
+	committed = true
+
+	ctx, cancel := gatewayContext(c, cfg)
+	defer cancel()
+
+	res, err := releaseAuthorization(ctx, db, gw, page, auth, txn)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Println("Gateway timed out, leaving void pending:", page.MerchantID, page.PageUID, txn.ID, err)
+		return c.JSON(http.StatusGatewayTimeout, map[string]any{"error": "void outcome unknown, it stays pending until confirmed", "details": err.Error()})
+	case errors.Is(err, errOutcomeNotRecorded):
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": errOutcomeNotRecorded.Error(), "details": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusBadGateway, map[string]any{"error": "void request failed", "details": err.Error()})
+	}
+	if !res.Approved {
+		status := http.StatusBadRequest
+		if res.HTTPStatus >= 400 {
+			status = res.HTTPStatus
+		}
+		return c.JSON(status, map[string]any{"approved": false, "message": res.Message})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"approved": true,
+		"message":  res.Message,
+		"status":   models.StatusVoided,
+	})
+}
+
+// RunAuthorizationSweeper voids authorizations that have been held longer
+// than maxAge, checking every interval until ctx is cancelled.
+func RunAuthorizationSweeper(ctx context.Context, db *gorm.DB, gateways gateway.Resolver, interval, maxAge, callTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			voidStaleAuthorizations(ctx, db, gateways, time.Now().Add(-maxAge), callTimeout)
+		}
+	}
+}
+
+func voidStaleAuthorizations(ctx context.Context, db *gorm.DB, gateways gateway.Resolver, cutoff time.Time, callTimeout time.Duration) {
+	var pages []models.PaymentPage
+	if err := db.WithContext(ctx).
+		Where("status = ? AND authorized_at < ?", models.StatusAuthorized, cutoff).
+		Find(&pages).Error; err != nil {
+		log.Println("Error finding stale authorizations:", err)
+		return
+	}
+
+	// Shutdown stops the sweep between pages, never halfway through one.
+	detached := context.WithoutCancel(ctx)
+	for _, p := range pages {
+		if ctx.Err() != nil {
+			return
+		}
+		gw := gateways(p.MerchantID)
+		if gw == nil {
+			continue
+		}
+		var page *models.PaymentPage
+		var auth, txn *models.Transaction
+		err := db.WithContext(detached).Transaction(func(tx *gorm.DB) error {
+			locked, err := lockPaymentPage(tx, p.MerchantID, p.PageUID)
+			if err != nil {
+				return err
+			}
+			// Captured or voided since we listed it.
+			if locked.Status != models.StatusAuthorized {
+				return nil
+			}
+			page = locked
+			auth, txn, err = holdAuthorization(tx, page, models.TransactionVoid, 0)
+			return err
+		})
+		if err != nil {
+			log.Println("Error voiding stale authorization:", p.MerchantID, p.PageUID, err)
+			continue
+		}
+		if txn == nil {
+			continue
+		}
+
+		callCtx, cancel := context.WithTimeout(detached, callTimeout)
+		res, err := releaseAuthorization(callCtx, db, gw, page, auth, txn)
+		cancel()
+		if err == nil && !res.Approved {
+			err = errors.New(res.Message)
+		}
+		if err != nil {
+			log.Println("Error voiding stale authorization:", p.MerchantID, p.PageUID, err)
+			continue
+		}
+		log.Println("Voided stale authorization:", p.MerchantID, p.PageUID)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+)
+
+func charge(t *testing.T, db *gorm.DB, gw gateway.PaymentGateway, page *models.PaymentPage, token, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Gateway.Timeout = 200 * time.Millisecond
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"datacap_token":"`+token+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(headerIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("merchant_id", "page_uid")
+	c.SetParamValues(page.MerchantID, page.PageUID)
+	if err := handleChargePayment(c, cfg, db, gateway.Single(gw)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func pageTransactions(t *testing.T, db *gorm.DB, page *models.PaymentPage) []models.Transaction {
+	t.Helper()
+	var txs []models.Transaction
+	if err := db.Order("id").Find(&txs, "merchant_id = ? AND page_uid = ?", page.MerchantID, page.PageUID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return txs
+}
+
+func TestChargePayment(t *testing.T) {
+	db := testDB(t)
+
+	tests := []struct {
+		token      string
+		wantCode   int
+		wantStatus models.PageStatus
+		wantLedger []string // kind:status
+	}{
+		{gateway.MockTokenApprove, http.StatusOK, models.StatusPaid,
+			[]string{"sale:" + models.TransactionApproved}},
+		{gateway.MockTokenDecline, http.StatusBadRequest, models.StatusOpen,
+			[]string{"sale:" + models.TransactionDeclined}},
+		{gateway.MockTokenPartial, http.StatusBadRequest, models.StatusOpen,
+			[]string{"sale:" + models.TransactionPartial, "void:" + models.TransactionApproved}},
+		{gateway.MockTokenError, http.StatusBadGateway, models.StatusOpen,
+			[]string{"sale:" + models.TransactionDeclined}},
+		{gateway.MockTokenHang, http.StatusGatewayTimeout, models.StatusProcessing,
+			[]string{"sale:" + models.TransactionPending}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			page := testPage(t, db)
+			rec := charge(t, db, gateway.NewMock(), page, tt.token, "")
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			var got models.PaymentPage
+			if err := db.First(&got, "merchant_id = ? AND page_uid = ?", page.MerchantID, page.PageUID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("page status = %s, want %s", got.Status, tt.wantStatus)
+			}
+
+			var ledger []string
+			for _, tx := range pageTransactions(t, db, page) {
+				ledger = append(ledger, tx.Kind+":"+tx.Status)
+			}
+			if strings.Join(ledger, ",") != strings.Join(tt.wantLedger, ",") {
+				t.Errorf("ledger = %v, want %v", ledger, tt.wantLedger)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+)
+
+const expiryBatchSize = 500
+
+// RunExpirySweeper moves open pages past their ExpireAt to "expired" every
+// interval until ctx is cancelled, so the database reflects expiry without
+// waiting for someone to open the link.
+func RunExpirySweeper(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expireDuePages(ctx, db, time.Now())
+		}
+	}
+}
+
+func expireDuePages(ctx context.Context, db *gorm.DB, now time.Time) {
+	var pages []models.PaymentPage
+	if err := db.WithContext(ctx).
+		Where("status = ? AND expire_at IS NOT NULL AND expire_at < ?", models.StatusOpen, now).
+		Order("expire_at ASC").
+		Limit(expiryBatchSize).
+		Find(&pages).Error; err != nil {
+		log.Println("Error finding expired payment pages:", err)
+		return
+	}
+
+	for i := range pages {
+		if ctx.Err() != nil {
+			return
+		}
+		// Transition skips pages a charge has picked up since the query.
+		expirePaymentPage(db.WithContext(ctx), &pages[i])
+	}
+	if len(pages) > 0 {
+		log.Println("Expired payment pages:", len(pages))
+	}
+}
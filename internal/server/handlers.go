@@ -11,16 +11,17 @@ import (
 	"log"
 	"math/big"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/skip2/go-qrcode"
 	"gorm.io/gorm"
 
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
 	"vitalink/internal/models"
+	"vitalink/internal/webhooks"
 )
 
 var pageUIDLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
@@ -37,9 +38,9 @@ func generatePageUID(length int) (string, error) {
 	return string(b), nil
 }
 
-func grabConfig(token string) (string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://api.vitabyte.info/api/config", nil) // todo change this to the prod url
+func grabConfig(upstream config.Upstream, token string) (string, error) {
+	client := &http.Client{Timeout: upstream.Timeout}
+	req, err := http.NewRequest("GET", upstream.ConfigURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("error creating request: %v", err)
 	}
@@ -67,35 +68,8 @@ func grabConfig(token string) (string, error) {
 	return response.MerchantID, nil
 }
 
-func handleCreatePaymentPage(c echo.Context, db *gorm.DB) error {
-	var req struct {
-		MerchantID  string     `json:"merchant_id"`
-		PageUID     string     `json:"page_uid"`
-		RvcID       string     `json:"rvc_id"`
-		AmountCents int64      `json:"amount_cents" validate:"required"`
-		Currency    string     `json:"currency"`
-		Title       string     `json:"title"`
-		Description string     `json:"description"`
-		StoreName   string     `json:"store_name"`
-		ExpireAt    *time.Time `json:"expire_at"`
-
-		InvoiceNo             string          `json:"invoice_no"`
-		IncludeTip            bool            `json:"include_tip"`
-		AllowedTipPercentages string          `json:"allowed_tip_percentages"`
-		PaymentFeeAmount      string          `json:"payment_fee_amount"`
-		PaymentFeeDescription string          `json:"payment_fee_description"`
-		SurchargeAmount       string          `json:"surcharge_amount"`
-		TaxAmount             string          `json:"tax_amount"`
-		Items                 json.RawMessage `json:"items"`
-		PaymentTypesAllowed   string          `json:"payment_types_allowed"`
-		PublicToken           string          `json:"public_token"`
-		ApplePayMid           string          `json:"apple_pay_mid"`
-		GooglePayMid          string          `json:"google_pay_mid"`
-		FeatureGraphic        string          `json:"feature_graphic"`
-		Logo                  string          `json:"logo"`
-		Logo2                 string          `json:"logo2"`
-		FavIcon               string          `json:"favicon"`
-	}
+func handleCreatePaymentPage(c echo.Context, cfg *config.Config, db *gorm.DB) error {
+	var req paymentPageInput
 
 	log.Println("Create payment page request received")
 	// pretty logging of request body
@@ -110,91 +84,32 @@ func handleCreatePaymentPage(c echo.Context, db *gorm.DB) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
 	}
-	if req.AmountCents == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]any{"error": "amount_cents is required"})
+	itemsJSON, err := req.normalize(cfg.Pages)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
 	}
 	if req.MerchantID == "" {
-		api_token := c.Request().Header.Get("Authorization")
-		log.Println("api_token", api_token)
-		merchantID, err := grabConfig(api_token)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]any{"error": "No merchant ID found and grabbing config failed", "details": err.Error()})
-		}
-		req.MerchantID = merchantID
+		req.MerchantID = authedMerchant(c)
+	}
+	if req.MerchantID != authedMerchant(c) {
+		return c.JSON(http.StatusForbidden, map[string]any{"error": "merchant_id does not match API key"})
 	}
 	if req.PageUID == "" {
-		if s, err := generatePageUID(10); err == nil {
+		if s, err := generatePageUID(cfg.Pages.UIDLength); err == nil {
 			req.PageUID = s
 		} else {
 			req.PageUID = strings.ReplaceAll(uuid.New().String()[:12], "-", "")
 		}
 	}
-	if req.RvcID == "" {
-		req.RvcID = "1" // default to 1 if not provided
-	}
-
-	if req.Currency == "" {
-		req.Currency = "USD"
-	}
-
-	// Validate and normalize items to a JSON string
-	itemsJSON := "[]"
-	type incomingItem struct {
-		Title       string  `json:"title"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price"`
-		Quantity    int     `json:"quantity"`
-		Total       float64 `json:"total"`
-	}
-	if len(req.Items) > 0 {
-		var arr []incomingItem
-		if err := json.Unmarshal(req.Items, &arr); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]any{"error": "items must be a JSON array of {title, description, price}"})
-		}
-		for i, it := range arr {
-			if strings.TrimSpace(it.Title) == "" || strings.TrimSpace(it.Description) == "" {
-				return c.JSON(http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("items[%d] missing title or description", i)})
-			}
-			// price can be zero but not negative
-			if it.Price < 0 {
-				return c.JSON(http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("items[%d] price must be >= 0", i)})
-			}
-		}
-		b, _ := json.Marshal(arr)
-		itemsJSON = string(b)
-	}
 	log.Println("Creating payment page for merchant:", req.MerchantID, "page UID:", req.PageUID)
 	log.Println("Apple Pay MID:", req.ApplePayMid)
 
 	pp := models.PaymentPage{
-		MerchantID:  req.MerchantID,
-		PageUID:     req.PageUID,
-		RvcID:       req.RvcID,
-		AmountCents: req.AmountCents,
-		Currency:    req.Currency,
-		Title:       req.Title,
-		Description: req.Description,
-		StoreName:   req.StoreName,
-		Status:      "open",
-		ExpireAt:    req.ExpireAt,
-
-		InvoiceNo:             req.InvoiceNo,
-		IncludeTip:            req.IncludeTip,
-		AllowedTipPercentages: req.AllowedTipPercentages,
-		PaymentFeeAmount:      req.PaymentFeeAmount,
-		PaymentFeeDescription: req.PaymentFeeDescription,
-		SurchargeAmount:       req.SurchargeAmount,
-		TaxAmount:             req.TaxAmount,
-		Items:                 itemsJSON,
-		PaymentTypesAllowed:   req.PaymentTypesAllowed,
-		PublicToken:           req.PublicToken,
-		ApplePayMid:           req.ApplePayMid,
-		GooglePayMid:          req.GooglePayMid,
-		FeatureGraphic:        req.FeatureGraphic,
-		Logo:                  req.Logo,
-		Logo2:                 req.Logo2,
-		FavIcon:               req.FavIcon,
+		MerchantID: req.MerchantID,
+		PageUID:    req.PageUID,
+		Status:     models.StatusOpen,
 	}
+	req.apply(&pp, itemsJSON)
 
 	if err := db.Create(&pp).Error; err != nil {
 		if isUnique(err) {
@@ -209,6 +124,8 @@ func handleCreatePaymentPage(c echo.Context, db *gorm.DB) error {
 		})
 	}
 
+	webhooks.Emit(db, pp.MerchantID, webhooks.EventPageCreated, pp)
+
 	scheme := "https"
 	if c.Scheme() != "" {
 		scheme = c.Scheme()
@@ -236,42 +153,30 @@ func handleViewPaymentPage(c echo.Context, db *gorm.DB) error {
 		return c.String(http.StatusInternalServerError, "error")
 	}
 
-	if pp.Status == "paid" {
-		return c.Render(http.StatusOK, "paid.html", map[string]any{"page": pp})
+	merchant, err := loadMerchant(db, pp.MerchantID)
+	if err != nil {
+		log.Println("Failed to load merchant branding:", err)
 	}
+	view := map[string]any{"page": pp, "merchant": merchant}
 
-	if pp.Status != "open" || pp.IsExpired(time.Now()) {
-		return c.Render(http.StatusOK, "expired.html", map[string]any{"page": pp})
+	switch pp.Status {
+	case models.StatusAuthorized, models.StatusPaid, models.StatusPartiallyRefunded, models.StatusRefunded, models.StatusVoided:
+		return c.Render(http.StatusOK, "paid.html", view)
 	}
-	log.Println("Rendering payment page for:", pp.MerchantID, pp.PageUID)
-	log.Println("Apple Pay MID:", pp.ApplePayMid)
-	log.Println("Google Pay MID:", pp.GooglePayMid)
-	return c.Render(http.StatusOK, "payment.html", map[string]any{"page": pp})
-}
-
-func handleQRPaymentPage(c echo.Context) error {
-	merchantID := c.Param("merchant_id")
-	pageUID := c.Param("page_uid")
 
-	scheme := "https"
-	if c.Scheme() != "" {
-		scheme = c.Scheme()
+	if pp.Status == models.StatusOpen && pp.IsExpired(time.Now()) {
+		expirePaymentPage(db, &pp)
 	}
-	host := c.Request().Host
-	url := scheme + "://" + host + "/p/" + merchantID + "/" + pageUID
-
-	sz := 256
-	if q := c.QueryParam("size"); q != "" {
-		if v, err := strconv.Atoi(q); err == nil && v >= 64 && v <= 2048 {
-			sz = v
-		}
+	if pp.Status == models.StatusCancelled {
+		return c.Render(http.StatusOK, "cancelled.html", view)
 	}
-
-	png, err := qrcode.Encode(url, qrcode.Medium, sz)
-	if err != nil {
-		return c.NoContent(http.StatusInternalServerError)
+	if (pp.Status != models.StatusOpen && pp.Status != models.StatusProcessing) || pp.IsExpired(time.Now()) {
+		return c.Render(http.StatusOK, "expired.html", view)
 	}
-	return c.Blob(http.StatusOK, "image/png", png)
+	log.Println("Rendering payment page for:", pp.MerchantID, pp.PageUID)
+	log.Println("Apple Pay MID:", pp.ApplePayMid)
+	log.Println("Google Pay MID:", pp.GooglePayMid)
+	return c.Render(http.StatusOK, "payment.html", view)
 }
 
 func isUnique(err error) bool {
@@ -282,24 +187,16 @@ func isUnique(err error) bool {
 	return strings.Contains(s, "duplicate key value") || strings.Contains(strings.ToLower(s), "unique")
 }
 
-func markPaymentFulfilled(ctx context.Context, db *gorm.DB, page *models.PaymentPage, dcResp map[string]any) error {
+func markPaymentFulfilled(ctx context.Context, db *gorm.DB, page *models.PaymentPage, res *gateway.Result) error {
 	if page == nil {
 		return errors.New("nil payment page")
 	}
-
-	approved := false
-	if v, ok := dcResp["Status"].(string); ok && strings.EqualFold(v, "Approved") {
-		approved = true
-	}
-	if v, ok := dcResp["CmdStatus"].(string); ok && strings.EqualFold(v, "Approved") {
-		approved = true
-	}
-	if !approved {
+	if res == nil || !res.Approved {
 		return errors.New("transaction not approved")
 	}
 
 	// In case of dupes
-	if page.Status == "paid" {
+	if page.Status == fulfilledStatus(page) {
 		return nil
 	}
 
@@ -310,11 +207,7 @@ func markPaymentFulfilled(ctx context.Context, db *gorm.DB, page *models.Payment
 		}
 	}()
 
-	if err := tx.Model(page).Updates(map[string]any{
-		"status": "paid",
-		"last4":  dcResp["Last4"],
-		"brand":  dcResp["Brand"],
-	}).Error; err != nil {
+	if err := fulfillPage(tx, page, res); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("database update failed: %w", err)
 	}
@@ -322,19 +215,88 @@ func markPaymentFulfilled(ctx context.Context, db *gorm.DB, page *models.Payment
 	if err := tx.Commit().Error; err != nil {
 		return fmt.Errorf("transaction commit failed: %w", err)
 	}
-
-	page.Status = "paid"
 	return nil
 }
-func getString(m map[string]any, key string) string {
-	if v, ok := m[key]; ok {
-		if s, ok := v.(string); ok {
-			return s
-		}
+
+// fulfilledStatus is where an approved charge leaves the page. Manual capture
+// pages stop at "authorized" until the merchant captures them.
+func fulfilledStatus(page *models.PaymentPage) models.PageStatus {
+	if page.CaptureMode == models.CaptureManual {
+		return models.StatusAuthorized
 	}
-	return ""
+	return models.StatusPaid
 }
-func handleFetchPaymentPageData(c echo.Context, db *gorm.DB) error {
+
+// fulfillPage moves a processing page to its fulfilled status within tx and
+// queues the page.paid webhook.
+func fulfillPage(tx *gorm.DB, page *models.PaymentPage, res *gateway.Result) error {
+	status := fulfilledStatus(page)
+	now := time.Now()
+	fields := map[string]any{
+		"last4": res.Last4,
+		"brand": res.Brand,
+	}
+	if status == models.StatusAuthorized {
+		fields["authorized_at"] = now
+	}
+	if err := page.Transition(tx, status, fields); err != nil {
+		return err
+	}
+	page.Last4 = res.Last4
+	page.Brand = res.Brand
+	if status == models.StatusAuthorized {
+		page.AuthorizedAt = &now
+	}
+	if status == models.StatusPaid {
+		webhooks.Emit(tx, page.MerchantID, webhooks.EventPagePaid, page)
+	}
+	return nil
+}
+
+// syncPaymentPage takes the amount, items and tip settings of an open page
+// from the check service's copy. They go through the same checks as an update
+// and are written only if the page is still open.
+func syncPaymentPage(db *gorm.DB, defaults config.Pages, pp, remote *models.PaymentPage) {
+	if remote.AmountCents == pp.AmountCents && remote.Items == pp.Items &&
+		remote.IncludeTip == pp.IncludeTip && remote.AllowedTipPercentages == pp.AllowedTipPercentages {
+		return
+	}
+	in := inputFromPage(pp)
+	in.AmountCents = remote.AmountCents
+	in.Items = json.RawMessage(remote.Items)
+	in.IncludeTip = remote.IncludeTip
+	in.AllowedTipPercentages = remote.AllowedTipPercentages
+	itemsJSON, err := in.normalize(defaults)
+	if err != nil {
+		log.Println("Ignoring remote amounts for payment page:", pp.MerchantID, pp.PageUID, err)
+		return
+	}
+
+	now := time.Now()
+	res := db.Model(&models.PaymentPage{}).
+		Where("merchant_id = ? AND page_uid = ? AND status = ?", pp.MerchantID, pp.PageUID, models.StatusOpen).
+		Updates(map[string]any{
+			"amount_cents":            in.AmountCents,
+			"items":                   itemsJSON,
+			"include_tip":             in.IncludeTip,
+			"allowed_tip_percentages": in.AllowedTipPercentages,
+			"updated_at":              now,
+		})
+	if res.Error != nil {
+		log.Println("Error syncing payment page:", pp.MerchantID, pp.PageUID, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	pp.AmountCents = in.AmountCents
+	pp.Items = itemsJSON
+	pp.IncludeTip = in.IncludeTip
+	pp.AllowedTipPercentages = in.AllowedTipPercentages
+	pp.UpdatedAt = now
+}
+
+func handleFetchPaymentPageData(c echo.Context, cfg *config.Config, db *gorm.DB) error {
 	merchantID := c.Param("merchant_id")
 	pageUID := c.Param("page_uid")
 
@@ -347,9 +309,9 @@ func handleFetchPaymentPageData(c echo.Context, db *gorm.DB) error {
 		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
 	}
 
-	// Try to fetch updated data from api.vitapay.com
-	client := &http.Client{Timeout: 10 * time.Second}
-	apiURL := fmt.Sprintf("https://api.vitapay.com/check/%s/%s", merchantID, pageUID)
+	// Try to fetch updated data from the VitaPay check service
+	client := &http.Client{Timeout: cfg.Upstream.Timeout}
+	apiURL := fmt.Sprintf("%s/%s/%s", strings.TrimRight(cfg.Upstream.CheckURL, "/"), merchantID, pageUID)
 
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
@@ -388,16 +350,17 @@ func handleFetchPaymentPageData(c echo.Context, db *gorm.DB) error {
 		})
 	}
 
-	// Update local database with fresh data if it's different
-	if apiData.AmountCents != pp.AmountCents || apiData.Status != pp.Status || apiData.Items != pp.Items ||
-		apiData.IncludeTip != pp.IncludeTip || apiData.AllowedTipPercentages != pp.AllowedTipPercentages {
-		pp.AmountCents = apiData.AmountCents
-		pp.Status = apiData.Status
-		pp.Items = apiData.Items
-		pp.IncludeTip = apiData.IncludeTip
-		pp.AllowedTipPercentages = apiData.AllowedTipPercentages
-		pp.UpdatedAt = time.Now()
-		db.Save(&pp)
+	// The remote status is only taken if it is a legal move from ours.
+	if apiData.Status != "" && apiData.Status != pp.Status {
+		if err := pp.Transition(db, apiData.Status, nil); err != nil {
+			log.Println("Ignoring remote status for payment page:", pp.MerchantID, pp.PageUID, err)
+		}
+	}
+
+	// Amounts are only taken while the page is open, so one that is being
+	// charged or is already settled keeps what the customer was shown.
+	if pp.Status == models.StatusOpen {
+		syncPaymentPage(db, cfg.Pages, &pp, &apiData)
 	}
 
 	return c.JSON(http.StatusOK, map[string]any{
@@ -407,7 +370,7 @@ func handleFetchPaymentPageData(c echo.Context, db *gorm.DB) error {
 	})
 }
 
-func handleChargePayment(c echo.Context, db *gorm.DB) error {
+func handleChargePayment(c echo.Context, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver) error {
 	merchantID := c.Param("merchant_id")
 	pageUID := c.Param("page_uid")
 
@@ -418,8 +381,37 @@ func handleChargePayment(c echo.Context, db *gorm.DB) error {
 		}
 		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
 	}
-	if page.Status != "open" || page.IsExpired(time.Now()) {
-		return c.JSON(http.StatusBadRequest, map[string]any{"error": "payment page closed or expired"})
+
+	bodyBytes, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid request"})
+	}
+	c.Request().Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	// Replays of a known Idempotency-Key get the original answer, even once the page is paid.
+	idem := &idempotency{db: db}
+	if key := strings.TrimSpace(c.Request().Header.Get(headerIdempotencyKey)); key != "" {
+		// A key is given up on once the reconciler would settle its charge.
+		rec, claimed, err := claimIdempotencyKey(db, page.MerchantID, page.PageUID, key, bodyBytes, cfg.Workers.ReconcileAfter)
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		case errors.Is(err, errIdempotencyInProgress):
+			return c.JSON(http.StatusConflict, map[string]any{"error": err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+		}
+		idem.rec = rec
+		if !claimed {
+			return idem.replay(c, &page)
+		}
+	}
+
+	if page.Status == models.StatusOpen && page.IsExpired(time.Now()) {
+		expirePaymentPage(db, &page)
+	}
+	if page.Status != models.StatusOpen || page.IsExpired(time.Now()) {
+		return idem.respond(c, http.StatusBadRequest, map[string]any{"error": "payment page closed or expired"})
 	}
 
 	var req struct {
@@ -430,118 +422,154 @@ func handleChargePayment(c echo.Context, db *gorm.DB) error {
 	}
 
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid request"})
+		return idem.respond(c, http.StatusBadRequest, map[string]any{"error": "invalid request"})
 	}
 
 	if strings.TrimSpace(req.DatacapToken) == "" {
-		return c.JSON(http.StatusBadRequest, map[string]any{"error": "datacap_token is required"})
+		return idem.respond(c, http.StatusBadRequest, map[string]any{"error": "datacap_token is required"})
 	}
 	log.Println("Charging payment for page:", page.MerchantID, page.PageUID)
 	log.Println("Token:", req.DatacapToken)
 
-	endpoint := "https://api.vitapay.com/v1/credit/sale"
-
 	if page.AmountCents < 1 {
-		return c.JSON(http.StatusBadRequest, map[string]any{"error": "amount must be at least 0.01"})
+		return idem.respond(c, http.StatusBadRequest, map[string]any{"error": "amount must be at least 0.01"})
+	}
+
+	if err := validateTip(&page, req.TipAmountCents); err != nil {
+		return idem.respond(c, http.StatusBadRequest, map[string]any{"error": err.Error()})
 	}
 
 	// Calculate total amount including tip
-	totalAmountCents := page.AmountCents + req.TipAmountCents
-	amount := fmt.Sprintf("%.2f", float64(totalAmountCents)/100)
+	totalAmountCents := page.GrandTotalCents(req.TipAmountCents)
 
-	payload := map[string]string{
-		"Token":        req.DatacapToken,
-		"Amount":       amount,
-		"Tax":          page.TaxAmount,
-		"CustomerCode": page.InvoiceNo, // InvoiceNo
-		"PartialAuth":  "Disallow",
-		"CardHolderID": "Allow_V2",
-		"InvoiceNo":    page.InvoiceNo,
-		"MerchantID":   page.MerchantID,
-		"PageUID":      page.PageUID,
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return idem.respond(c, http.StatusInternalServerError, map[string]any{"error": "no payment gateway configured"})
 	}
 
-	if page.InvoiceNo != "" {
-		payload["InvoiceNo"] = page.InvoiceNo
-	}
-	if page.PaymentFeeAmount != "" {
-		payload["PaymentFee"] = page.PaymentFeeAmount
-	}
-	if page.PaymentFeeDescription != "" {
-		payload["PaymentFeeDescription"] = page.PaymentFeeDescription
-	}
-	if page.SurchargeAmount != "" {
-		payload["SurchargeWithLookup"] = page.SurchargeAmount
-	}
-	if page.TaxAmount != "" {
-		payload["Tax"] = page.TaxAmount
+	kind, call := models.TransactionSale, gw.Sale
+	if page.CaptureMode == models.CaptureManual {
+		kind, call = models.TransactionAuthorization, gw.Authorize
 	}
 
-	bodyBytes, err := json.Marshal(payload)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "marshal error"})
+	// Only one request may move the page out of "open"; everyone else is turned away
+	// before reaching the gateway.
+	if err := page.Transition(db, models.StatusProcessing, nil); err != nil {
+		var terr *models.TransitionError
+		if errors.Is(err, models.ErrStatusConflict) || errors.As(err, &terr) {
+			return idem.respond(c, http.StatusConflict, map[string]any{"error": "payment already in progress or completed"})
+		}
+		return idem.respond(c, http.StatusInternalServerError, map[string]any{"error": "db error"})
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request().Context(), 15*time.Second)
+	ctx, cancel := gatewayContext(c, cfg)
 	defer cancel()
 
-	reqHttp, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(bodyBytes))
+	// The attempt is on the ledger before the card is charged. If the process
+	// dies or the database fails once the gateway has answered, the pending
+	// row is left for the reconciler.
+	txn, err := beginTransaction(db, &page, kind, totalAmountCents, req.TipAmountCents)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "request build error"})
+		log.Println("Error recording charge attempt:", page.MerchantID, page.PageUID, err)
+		reopenPaymentPage(db, &page)
+		return idem.respond(c, http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	idem.started(txn)
+
+	res, err := call(ctx, gateway.SaleRequest{
+		Token:                 req.DatacapToken,
+		AmountCents:           totalAmountCents,
+		Currency:              page.Currency,
+		MerchantID:            page.MerchantID,
+		PageUID:               page.PageUID,
+		InvoiceNo:             page.InvoiceNo,
+		TaxCents:              page.TaxCents,
+		PaymentFeeCents:       page.PaymentFeeCents,
+		PaymentFeeDescription: page.PaymentFeeDescription,
+		SurchargeCents:        page.SurchargeCents,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The request may have reached the gateway and been approved. Leave
+		// the attempt pending and the page processing for the reconciler
+		// rather than let the customer pay twice.
+		log.Println("Gateway timed out, leaving charge to the reconciler:", page.MerchantID, page.PageUID, err)
+		return idem.respond(c, http.StatusGatewayTimeout, map[string]any{"error": "payment outcome unknown, it will be confirmed shortly", "details": err.Error()})
 	}
-	reqHttp.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(reqHttp)
 	if err != nil {
-		return c.JSON(http.StatusBadGateway, map[string]any{"error": "datacap request failed", "details": err.Error()})
+		settleTransaction(db, txn, nil, err)
+		reopenPaymentPage(db, &page)
+		return idem.respond(c, http.StatusBadGateway, map[string]any{"error": "datacap request failed", "details": err.Error()})
 	}
-	defer resp.Body.Close()
-	respBytes, _ := io.ReadAll(resp.Body)
-
-	var dcResp map[string]any
-	_ = json.Unmarshal(respBytes, &dcResp)
 
 	// Fallback to client-provided metadata if gateway response omits these
-	if dcResp == nil {
-		dcResp = map[string]any{}
-	}
-	if _, ok := dcResp["Last4"]; !ok && strings.TrimSpace(req.Last4) != "" {
-		dcResp["Last4"] = req.Last4
-	}
-	if _, ok := dcResp["Brand"]; !ok && strings.TrimSpace(req.Brand) != "" {
-		dcResp["Brand"] = req.Brand
-	}
-
-	approved := false
-	message := ""
-	if v, ok := dcResp["Status"].(string); ok && strings.EqualFold(v, "Approved") {
-		approved = true
-	}
-	if v, ok := dcResp["Message"].(string); ok && message == "" {
-		message = v
-	}
-	if message == "" {
-		message = strings.TrimSpace(string(respBytes))
-	}
-
-	if resp.StatusCode >= 400 {
-		approved = false
+	if res.Last4 == "" {
+		res.Last4 = strings.TrimSpace(req.Last4)
+	}
+	if res.Brand == "" {
+		res.Brand = strings.TrimSpace(req.Brand)
+	}
+	settleTransaction(db, txn, res, nil)
+
+	// PartialAuth is disallowed, so a short approval is released rather than
+	// recorded as a payment for less than the page amount.
+	if res.Partial(totalAmountCents) {
+		log.Println("Partial approval for page:", page.MerchantID, page.PageUID, "voiding", res.RefNo)
+		voidRes, err := gw.Void(ctx, gateway.VoidRequest{
+			RefNo:      res.RefNo,
+			MerchantID: page.MerchantID,
+			PageUID:    page.PageUID,
+			InvoiceNo:  page.InvoiceNo,
+		})
+		if err != nil {
+			log.Println("Error voiding partial approval:", err)
+		}
+		recordTransaction(db, &page, models.TransactionVoid, res.AuthorizedCents, 0, voidRes, err)
+		res.Approved = false
+		res.Message = "partial approval not accepted"
 	}
 
-	if approved {
-		_ = markPaymentFulfilled(ctx, db, &page, dcResp)
-		return c.JSON(http.StatusOK, map[string]any{
+	if res.Approved {
+		// The card has been charged, so the customer is told so either way. A
+		// page that could not be updated stays "processing", which blocks a
+		// second charge, until the reconciler finalizes it.
+		if err := markPaymentFulfilled(context.WithoutCancel(ctx), db, &page, res); err != nil {
+			log.Println("Error marking payment fulfilled, leaving it to the reconciler:", page.MerchantID, page.PageUID, err)
+		}
+		return idem.respond(c, http.StatusOK, map[string]any{
 			"approved": true,
-			"message":  message,
+			"message":  res.Message,
 		})
 	}
 
+	reopenPaymentPage(db, &page)
+	webhooks.Emit(db, page.MerchantID, webhooks.EventPaymentDeclined, map[string]any{
+		"page":        page,
+		"transaction": txn,
+	})
 	status := http.StatusBadRequest
-	if resp.StatusCode >= 400 {
-		status = resp.StatusCode
+	if res.HTTPStatus >= 400 {
+		status = res.HTTPStatus
 	}
-	return c.JSON(status, map[string]any{
+	return idem.respond(c, status, map[string]any{
 		"approved": false,
-		"message":  message,
+		"message":  res.Message,
 	})
 }
+
+// expirePaymentPage records that an open page is past its ExpireAt and tells
+// the merchant about it.
+func expirePaymentPage(db *gorm.DB, page *models.PaymentPage) {
+	if err := page.Transition(db, models.StatusExpired, nil); err != nil {
+		log.Println("Error expiring payment page:", page.MerchantID, page.PageUID, err)
+		return
+	}
+	webhooks.Emit(db, page.MerchantID, webhooks.EventPageExpired, page)
+}
+
+// reopenPaymentPage hands a page back to "open" after a charge attempt that did
+// not go through, so the customer can try another card.
+func reopenPaymentPage(db *gorm.DB, page *models.PaymentPage) {
+	if err := page.Transition(db, models.StatusOpen, nil); err != nil {
+		log.Println("Error reopening payment page:", page.MerchantID, page.PageUID, err)
+	}
+}
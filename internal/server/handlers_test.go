@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"vitalink/internal/config"
+	"vitalink/internal/models"
+)
+
+func TestSyncPaymentPage(t *testing.T) {
+	db := testDB(t)
+	defaults := config.Default().Pages
+
+	tests := []struct {
+		name       string
+		status     models.PageStatus
+		remote     models.PaymentPage
+		wantAmount int64
+	}{
+		{"open page takes remote amount", models.StatusOpen,
+			models.PaymentPage{AmountCents: 2500, Items: "[]"}, 2500},
+		{"itemized amount is recomputed", models.StatusOpen,
+			models.PaymentPage{Items: `[{"title":"Tea","description":"Green","price":300,"quantity":2}]`}, 600},
+		{"itemized mismatch is ignored", models.StatusOpen,
+			models.PaymentPage{AmountCents: 999, Items: `[{"title":"Tea","description":"Green","price":300,"quantity":2}]`}, 1000},
+		{"processing page keeps its amount", models.StatusProcessing,
+			models.PaymentPage{AmountCents: 2500, Items: "[]"}, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := testPage(t, db)
+			if tt.status != models.StatusOpen {
+				if err := page.Transition(db, tt.status, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			syncPaymentPage(db, defaults, page, &tt.remote)
+
+			var got models.PaymentPage
+			if err := db.First(&got, "merchant_id = ? AND page_uid = ?", page.MerchantID, page.PageUID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if got.AmountCents != tt.wantAmount || page.AmountCents != tt.wantAmount {
+				t.Errorf("amount = %d stored, %d returned; want %d", got.AmountCents, page.AmountCents, tt.wantAmount)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/config"
+	"vitalink/internal/version"
+)
+
+// handleHealthz reports that the process is up and serving. It checks nothing
+// else, so a database outage does not get the container restarted.
+func handleHealthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// handleReadyz reports whether this instance can take traffic: the database
+// answers and, when Upstream.ReadyCheck is set, so does the config service.
+func handleReadyz(c echo.Context, cfg *config.Config, db *gorm.DB) error {
+	checks := map[string]string{}
+	ready := true
+	run := func(name string, check func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), cfg.Server.ReadyTimeout)
+		defer cancel()
+		if err := check(ctx); err != nil {
+			checks[name] = err.Error()
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	run("database", func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+	if cfg.Upstream.ReadyCheck {
+		run("config_service", func(ctx context.Context) error { return pingUpstream(ctx, cfg.Upstream.ConfigURL) })
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	return c.JSON(code, map[string]any{"status": status, "checks": checks})
+}
+
+// pingUpstream succeeds if url answers at all below 500; the config service
+// rejects requests without a token, which still shows it is reachable.
+func pingUpstream(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+var startedAt = time.Now()
+
+func handleVersion(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"build":          version.Get(),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+)
+
+const headerIdempotencyKey = "Idempotency-Key"
+
+var (
+	errIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+	errIdempotencyMismatch   = errors.New("idempotency key was already used with a different request body")
+)
+
+// idempotency records the response to a request under its Idempotency-Key.
+// A nil rec means the caller sent no key and responses are not stored.
+type idempotency struct {
+	db  *gorm.DB
+	rec *models.IdempotencyKey
+}
+
+func hashRequestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey reserves key for the page. If the key was seen before the
+// stored record is returned with claimed false and the caller should replay it.
+// A key still in progress after staleAfter belonged to a request that died; it
+// is taken over if that request never reached the gateway, and otherwise
+// replayed from the ledger.
+func claimIdempotencyKey(db *gorm.DB, merchantID, pageUID, key string, body []byte, staleAfter time.Duration) (*models.IdempotencyKey, bool, error) {
+	rec := models.IdempotencyKey{
+		MerchantID:  merchantID,
+		PageUID:     pageUID,
+		Key:         key,
+		RequestHash: hashRequestBody(body),
+	}
+	err := db.Create(&rec).Error
+	if err == nil {
+		return &rec, true, nil
+	}
+	if !isUnique(err) {
+		return nil, false, err
+	}
+
+	var existing models.IdempotencyKey
+	if err := db.First(&existing, "merchant_id = ? AND page_uid = ? AND key = ?", merchantID, pageUID, key).Error; err != nil {
+		return nil, false, err
+	}
+	if existing.RequestHash != rec.RequestHash {
+		return &existing, false, errIdempotencyMismatch
+	}
+	if existing.Completed {
+		return &existing, false, nil
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	if existing.UpdatedAt.After(cutoff) {
+		return &existing, false, errIdempotencyInProgress
+	}
+	if existing.TransactionID != nil {
+		return &existing, false, nil
+	}
+	// Only one retry may take the key over.
+	res := db.Model(&existing).
+		Where("completed = ? AND updated_at < ?", false, cutoff).
+		Update("updated_at", time.Now())
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &existing, false, errIdempotencyInProgress
+	}
+	return &existing, true, nil
+}
+
+// replay answers a request whose key was already used. A request that started
+// a charge but gave no final answer, because it timed out or died, is answered
+// from what the ledger now says about that charge; once the charge is settled
+// that answer is stored like any other.
+func (i *idempotency) replay(c echo.Context, page *models.PaymentPage) error {
+	rec := i.rec
+	if rec.TransactionID == nil || (rec.Completed && rec.StatusCode != http.StatusGatewayTimeout) {
+		c.Response().Header().Set("Idempotent-Replayed", "true")
+		return c.JSONBlob(rec.StatusCode, []byte(rec.ResponseBody))
+	}
+
+	var t models.Transaction
+	if err := i.db.First(&t, *rec.TransactionID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	c.Response().Header().Set("Idempotent-Replayed", "true")
+	switch {
+	case t.Status == models.TransactionPending || page.Status == models.StatusProcessing:
+		return c.JSON(http.StatusGatewayTimeout, map[string]any{"error": "payment outcome unknown, it will be confirmed shortly"})
+	case t.Status == models.TransactionApproved && page.Status != models.StatusOpen && !paidByOther(i.db, page, t.ID):
+		return i.respond(c, http.StatusOK, map[string]any{
+			"approved": true,
+			"message":  t.Message,
+		})
+	case t.Status == models.TransactionError:
+		return i.respond(c, http.StatusBadGateway, map[string]any{"error": "datacap request failed", "details": t.Error})
+	}
+	status := http.StatusBadRequest
+	if t.HTTPStatus >= 400 {
+		status = t.HTTPStatus
+	}
+	message := t.Message
+	switch t.Status {
+	case models.TransactionPartial:
+		message = "partial approval not accepted"
+	case models.TransactionApproved, models.TransactionVoided:
+		// Released by the reconciler: the page was no longer waiting for it.
+		message = "payment not accepted"
+	}
+	return i.respond(c, status, map[string]any{
+		"approved": false,
+		"message":  message,
+	})
+}
+
+// started records the charge attempt the request is about to make.
+func (i *idempotency) started(t *models.Transaction) {
+	if i == nil || i.rec == nil {
+		return
+	}
+	if err := i.db.Model(i.rec).Update("transaction_id", t.ID).Error; err != nil {
+		log.Println("Error linking idempotency key to charge attempt:", err)
+	}
+	i.rec.TransactionID = &t.ID
+}
+
+// respond sends the response and stores it under the key. A 504 is stored so
+// replays can look up how the charge ended; any other server error releases
+// the key, since nothing was charged and the request may simply be retried.
+func (i *idempotency) respond(c echo.Context, status int, body map[string]any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "marshal error"})
+	}
+	if i != nil && i.rec != nil {
+		if status >= 500 && status != http.StatusGatewayTimeout {
+			if err := i.db.Delete(i.rec).Error; err != nil {
+				log.Println("Error releasing idempotency key:", err)
+			}
+		} else if err := i.db.Model(i.rec).Updates(map[string]any{
+			"completed":     true,
+			"status_code":   status,
+			"response_body": string(b),
+		}).Error; err != nil {
+			log.Println("Error storing idempotent response:", err)
+		}
+	}
+	return c.JSONBlob(status, b)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"vitalink/internal/gateway"
+)
+
+func TestChargePaymentIdempotency(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+	gw := gateway.NewMock()
+	key := "key-" + page.PageUID
+
+	first := charge(t, db, gw, page, gateway.MockTokenApprove, key)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first charge = %d: %s", first.Code, first.Body)
+	}
+	again := charge(t, db, gw, page, gateway.MockTokenApprove, key)
+	if again.Code != http.StatusOK || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay = %d replayed=%q, want 200 replayed", again.Code, again.Header().Get("Idempotent-Replayed"))
+	}
+	var a, b map[string]any
+	json.Unmarshal(first.Body.Bytes(), &a)
+	json.Unmarshal(again.Body.Bytes(), &b)
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("replayed body = %v, want %v", b, a)
+	}
+	if n := len(pageTransactions(t, db, page)); n != 1 {
+		t.Errorf("%d transactions after replay, want 1", n)
+	}
+
+	mismatch := charge(t, db, gw, page, gateway.MockTokenDecline, key)
+	if mismatch.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different body under the same key = %d, want 422", mismatch.Code)
+	}
+}
+
+func TestChargePaymentTimeoutReplay(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+	key := "key-" + page.PageUID
+
+	rec := charge(t, db, gateway.NewMock(), page, gateway.MockTokenHang, key)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("hung charge = %d, want 504", rec.Code)
+	}
+	// Until the charge is settled, a replay still cannot say how it went.
+	rec = charge(t, db, gateway.NewMock(), page, gateway.MockTokenHang, key)
+	if rec.Code != http.StatusGatewayTimeout || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay of pending charge = %d, want replayed 504", rec.Code)
+	}
+}
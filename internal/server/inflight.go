@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"vitalink/internal/config"
+)
+
+// Inflight tracks requests that move money through a gateway so shutdown can
+// wait for their results to be written before the process exits. Once Drain
+// has been called new requests are turned away with 503 and can be retried
+// against another instance.
+type Inflight struct {
+	mu       sync.Mutex
+	draining bool
+	next     uint64
+	active   map[uint64]string
+	idle     chan struct{}
+}
+
+func NewInflight() *Inflight {
+	return &Inflight{active: make(map[uint64]string)}
+}
+
+// begin registers a request described by label. It reports false once the
+// server is draining.
+func (f *Inflight) begin(label string) (uint64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return 0, false
+	}
+	f.next++
+	f.active[f.next] = label
+	return f.next, true
+}
+
+func (f *Inflight) end(id uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.active, id)
+	if len(f.active) == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+// Middleware wraps a gateway-calling route.
+func (f *Inflight) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, ok := f.begin(c.Request().Method + " " + c.Request().URL.Path)
+		if !ok {
+			c.Response().Header().Set("Retry-After", "5")
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{"error": "server is shutting down, retry shortly"})
+		}
+		defer f.end(id)
+		return next(c)
+	}
+}
+
+// Drain stops new requests from starting and waits until those under way have
+// finished or ctx is done. It returns the requests still running, if any.
+func (f *Inflight) Drain(ctx context.Context) []string {
+	f.mu.Lock()
+	f.draining = true
+	if len(f.active) == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	left := make([]string, 0, len(f.active))
+	for _, label := range f.active {
+		left = append(left, label)
+	}
+	sort.Strings(left)
+	return left
+}
+
+// gatewayContext returns the context for a request's gateway calls. It keeps
+// the request's values but not its cancellation, so neither the client going
+// away nor the server shutting down can cut off recording what the gateway
+// did; only the configured gateway timeout bounds it.
+func gatewayContext(c echo.Context, cfg *config.Config) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(c.Request().Context()), cfg.Gateway.Timeout)
+}
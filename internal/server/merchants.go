@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+)
+
+var (
+	hexColor   = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	fontFamily = regexp.MustCompile(`^[A-Za-z0-9 \-]{1,64}$`)
+)
+
+// merchantInput is the editable part of a merchant profile.
+type merchantInput struct {
+	Name string `json:"name"`
+	models.Branding
+}
+
+func (in *merchantInput) validate() error {
+	if err := validateAssetURLs(map[string]string{
+		"logo": in.Logo, "logo2": in.Logo2, "favicon": in.FavIcon, "feature_graphic": in.FeatureGraphic,
+	}); err != nil {
+		return err
+	}
+	for field, v := range map[string]string{"primary_color": in.PrimaryColor, "accent_color": in.AccentColor} {
+		if v != "" && !hexColor.MatchString(v) {
+			return fmt.Errorf("%s must be a hex color like #7c3aed", field)
+		}
+	}
+	if in.FontFamily != "" && !fontFamily.MatchString(in.FontFamily) {
+		return fmt.Errorf("font_family may only contain letters, digits, spaces and dashes")
+	}
+	if in.SupportEmail != "" {
+		if _, err := mail.ParseAddress(in.SupportEmail); err != nil {
+			return fmt.Errorf("support_email is not a valid address")
+		}
+	}
+	in.SupportPhone = strings.TrimSpace(in.SupportPhone)
+	in.FooterText = strings.TrimSpace(in.FooterText)
+	return nil
+}
+
+// validateAssetURLs checks that branding images, keyed by field name, are
+// absolute https URLs. The logo is fetched server-side for QR codes, and that
+// fetch will not use anything else.
+func validateAssetURLs(urls map[string]string) error {
+	for field, v := range urls {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute https URL", field)
+		}
+	}
+	return nil
+}
+
+// loadMerchant returns the merchant's profile, or an empty one if it was
+// never saved.
+func loadMerchant(db *gorm.DB, merchantID string) (*models.Merchant, error) {
+	m := models.Merchant{MerchantID: merchantID}
+	if err := db.Limit(1).Find(&m, "merchant_id = ?", merchantID).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func handleGetMerchant(c echo.Context, db *gorm.DB) error {
+	m, err := loadMerchant(db, authedMerchant(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": m})
+}
+
+// handleUpdateMerchant changes the profile fields present in the body; the
+// rest keep their values. Branding applies to every page that does not set
+// its own.
+func handleUpdateMerchant(c echo.Context, db *gorm.DB) error {
+	m, err := loadMerchant(db, authedMerchant(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	in := merchantInput{Name: m.Name, Branding: m.Branding}
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+	if err := in.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+
+	m.Name = in.Name
+	m.Branding = in.Branding
+	if err := db.Save(m).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "update failed", "details": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": m})
+}
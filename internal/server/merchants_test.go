@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"vitalink/internal/models"
+)
+
+func TestMerchantInputValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		branding models.Branding
+		wantErr  bool
+	}{
+		{"empty", models.Branding{}, false},
+		{"full", models.Branding{
+			Logo:         "https://cdn.example.com/logo.png",
+			PrimaryColor: "#7c3aed",
+			AccentColor:  "#fff",
+			FontFamily:   "Open Sans",
+			SupportEmail: "help@example.com",
+		}, false},
+		{"http logo", models.Branding{Logo: "http://cdn.example.com/logo.png"}, true},
+		{"relative logo", models.Branding{Logo: "/static/logo.png"}, true},
+		{"script favicon", models.Branding{FavIcon: "javascript:alert(1)"}, true},
+		{"named color", models.Branding{PrimaryColor: "purple"}, true},
+		{"color without hash", models.Branding{AccentColor: "7c3aed"}, true},
+		{"css in font", models.Branding{FontFamily: "x; background: url(evil)"}, true},
+		{"bad email", models.Branding{SupportEmail: "not an address"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := merchantInput{Branding: tt.branding}
+			err := in.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAssetURLs(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://cdn.example.com/logo.png", false},
+		{"http://cdn.example.com/logo.png", true},
+		{"file:///etc/passwd", true},
+		{"https:///logo.png", true},
+		{"/static/logo.png", true},
+		{"gopher://example.com", true},
+	}
+	for _, tt := range tests {
+		err := validateAssetURLs(map[string]string{"logo_url": tt.url})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateAssetURLs(%q) = %v, want error %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
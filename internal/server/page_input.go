@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"vitalink/internal/config"
+	"vitalink/internal/currency"
+	"vitalink/internal/i18n"
+	"vitalink/internal/models"
+)
+
+// paymentPageInput is the body accepted when creating or updating a payment
+// page. Both paths run it through normalize so they enforce the same rules.
+type paymentPageInput struct {
+	MerchantID  string     `json:"merchant_id"`
+	PageUID     string     `json:"page_uid"`
+	RvcID       string     `json:"rvc_id"`
+	AmountCents int64      `json:"amount_cents" validate:"required"`
+	Currency    string     `json:"currency"`
+	Locale      string     `json:"locale"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	StoreName   string     `json:"store_name"`
+	ExpireAt    *time.Time `json:"expire_at"`
+	CaptureMode string     `json:"capture_mode"`
+
+	InvoiceNo             string          `json:"invoice_no"`
+	IncludeTip            bool            `json:"include_tip"`
+	AllowedTipPercentages string          `json:"allowed_tip_percentages"`
+	MaxTipCents           int64           `json:"max_tip_cents"`
+	PaymentFeeCents       int64           `json:"payment_fee_cents"`
+	PaymentFeeDescription string          `json:"payment_fee_description"`
+	SurchargeCents        int64           `json:"surcharge_cents"`
+	TaxCents              int64           `json:"tax_cents"`
+	Items                 json.RawMessage `json:"items"`
+	PaymentTypesAllowed   string          `json:"payment_types_allowed"`
+	PublicToken           string          `json:"public_token"`
+	ApplePayMid           string          `json:"apple_pay_mid"`
+	GooglePayMid          string          `json:"google_pay_mid"`
+	FeatureGraphic        string          `json:"feature_graphic"`
+	Logo                  string          `json:"logo"`
+	Logo2                 string          `json:"logo2"`
+	FavIcon               string          `json:"favicon"`
+
+	// Decimal strings accepted from clients that predate the *_cents fields.
+	PaymentFeeAmount string `json:"payment_fee_amount"`
+	SurchargeAmount  string `json:"surcharge_amount"`
+	TaxAmount        string `json:"tax_amount"`
+}
+
+// editablePageColumns are the columns an update may write; identity, status and
+// payment results are not among them.
+var editablePageColumns = []string{
+	"rvc_id", "amount_cents", "currency", "locale", "title", "description", "store_name", "expire_at", "capture_mode",
+	"invoice_no", "include_tip", "allowed_tip_percentages", "max_tip_cents", "payment_fee_cents", "payment_fee_description",
+	"surcharge_cents", "tax_cents", "items", "payment_types_allowed", "public_token", "apple_pay_mid",
+	"google_pay_mid", "feature_graphic", "logo", "logo2", "favicon",
+}
+
+// inputFromPage starts an update from the page's current values, so fields
+// missing from a PATCH body keep what they had.
+func inputFromPage(pp *models.PaymentPage) paymentPageInput {
+	return paymentPageInput{
+		MerchantID:  pp.MerchantID,
+		PageUID:     pp.PageUID,
+		RvcID:       pp.RvcID,
+		AmountCents: pp.AmountCents,
+		Currency:    pp.Currency,
+		Locale:      pp.Locale,
+		Title:       pp.Title,
+		Description: pp.Description,
+		StoreName:   pp.StoreName,
+		ExpireAt:    pp.ExpireAt,
+		CaptureMode: pp.CaptureMode,
+
+		InvoiceNo:             pp.InvoiceNo,
+		IncludeTip:            pp.IncludeTip,
+		AllowedTipPercentages: pp.AllowedTipPercentages,
+		MaxTipCents:           pp.MaxTipCents,
+		PaymentFeeCents:       pp.PaymentFeeCents,
+		PaymentFeeDescription: pp.PaymentFeeDescription,
+		SurchargeCents:        pp.SurchargeCents,
+		TaxCents:              pp.TaxCents,
+		Items:                 json.RawMessage(pp.Items),
+		PaymentTypesAllowed:   pp.PaymentTypesAllowed,
+		PublicToken:           pp.PublicToken,
+		ApplePayMid:           pp.ApplePayMid,
+		GooglePayMid:          pp.GooglePayMid,
+		FeatureGraphic:        pp.FeatureGraphic,
+		Logo:                  pp.Logo,
+		Logo2:                 pp.Logo2,
+		FavIcon:               pp.FavIcon,
+	}
+}
+
+// normalize fills defaults and validates the input, returning the items as a
+// normalized JSON string. When items are given, AmountCents must equal their
+// total plus tax, fee and surcharge; it is computed if left out.
+func (in *paymentPageInput) normalize(defaults config.Pages) (string, error) {
+	if in.AmountCents < 0 {
+		return "", errors.New("amount_cents must be >= 0")
+	}
+	if in.Currency == "" {
+		in.Currency = "USD"
+	}
+	cur, ok := currency.Lookup(in.Currency)
+	if !ok {
+		return "", fmt.Errorf("currency %q is not an ISO 4217 code", in.Currency)
+	}
+	in.Currency = cur.Code
+	if in.Locale != "" && !i18n.ValidTag(in.Locale) {
+		return "", fmt.Errorf("locale %q is not a language tag like \"es\" or \"fr-CA\"", in.Locale)
+	}
+	if err := validateAssetURLs(map[string]string{
+		"logo": in.Logo, "logo2": in.Logo2, "favicon": in.FavIcon, "feature_graphic": in.FeatureGraphic,
+	}); err != nil {
+		return "", err
+	}
+	for _, legacy := range []struct {
+		name  string
+		value string
+		cents *int64
+	}{
+		{"payment_fee_amount", in.PaymentFeeAmount, &in.PaymentFeeCents},
+		{"surcharge_amount", in.SurchargeAmount, &in.SurchargeCents},
+		{"tax_amount", in.TaxAmount, &in.TaxCents},
+	} {
+		if legacy.value == "" {
+			continue
+		}
+		cents, err := cur.ParseDecimal(legacy.value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", legacy.name, err)
+		}
+		*legacy.cents = cents
+	}
+	if in.TaxCents < 0 || in.PaymentFeeCents < 0 || in.SurchargeCents < 0 {
+		return "", errors.New("tax_cents, payment_fee_cents and surcharge_cents must be >= 0")
+	}
+	if in.RvcID == "" {
+		in.RvcID = defaults.DefaultRvcID
+	}
+
+	if _, err := parseTipPercentages(in.AllowedTipPercentages); err != nil {
+		return "", err
+	}
+	if in.MaxTipCents < 0 {
+		return "", errors.New("max_tip_cents must be >= 0")
+	}
+
+	switch in.CaptureMode {
+	case "":
+		in.CaptureMode = models.CaptureAutomatic
+	case models.CaptureAutomatic, models.CaptureManual:
+	default:
+		return "", errors.New("capture_mode must be automatic or manual")
+	}
+
+	// Validate and normalize items to a JSON string
+	items, err := models.ParseItems(in.Items)
+	if err != nil {
+		return "", err
+	}
+	var itemsTotal int64
+	for i, it := range items {
+		if strings.TrimSpace(it.Title) == "" || strings.TrimSpace(it.Description) == "" {
+			return "", fmt.Errorf("items[%d] missing title or description", i)
+		}
+		// price can be zero but not negative
+		if it.PriceCents < 0 {
+			return "", fmt.Errorf("items[%d] price must be >= 0", i)
+		}
+		if it.Quantity < 0 {
+			return "", fmt.Errorf("items[%d] quantity must be >= 0", i)
+		}
+		if it.TotalCents != 0 && it.TotalCents != (models.LineItem{PriceCents: it.PriceCents, Quantity: it.Quantity}).LineTotalCents() {
+			return "", fmt.Errorf("items[%d] total does not equal price × quantity", i)
+		}
+		itemsTotal += it.LineTotalCents()
+	}
+
+	extras := in.TaxCents + in.PaymentFeeCents + in.SurchargeCents
+	if len(items) > 0 {
+		itemized := itemsTotal + extras
+		if in.AmountCents == 0 {
+			in.AmountCents = itemized
+		} else if in.AmountCents != itemized {
+			return "", fmt.Errorf("amount_cents %d does not match itemized total %d (items %d + tax %d + fee %d + surcharge %d)",
+				in.AmountCents, itemized, itemsTotal, in.TaxCents, in.PaymentFeeCents, in.SurchargeCents)
+		}
+	}
+	if in.AmountCents == 0 {
+		return "", errors.New("amount_cents is required")
+	}
+	if in.AmountCents < extras {
+		return "", errors.New("amount_cents must cover tax, fee and surcharge")
+	}
+
+	itemsJSON := "[]"
+	if len(items) > 0 {
+		b, _ := json.Marshal(items)
+		itemsJSON = string(b)
+	}
+	return itemsJSON, nil
+}
+
+// apply copies the editable fields onto pp.
+func (in *paymentPageInput) apply(pp *models.PaymentPage, itemsJSON string) {
+	pp.RvcID = in.RvcID
+	pp.AmountCents = in.AmountCents
+	pp.Currency = in.Currency
+	pp.Locale = in.Locale
+	pp.Title = in.Title
+	pp.Description = in.Description
+	pp.StoreName = in.StoreName
+	pp.ExpireAt = in.ExpireAt
+	pp.CaptureMode = in.CaptureMode
+
+	pp.InvoiceNo = in.InvoiceNo
+	pp.IncludeTip = in.IncludeTip
+	pp.AllowedTipPercentages = in.AllowedTipPercentages
+	pp.MaxTipCents = in.MaxTipCents
+	pp.PaymentFeeCents = in.PaymentFeeCents
+	pp.PaymentFeeDescription = in.PaymentFeeDescription
+	pp.SurchargeCents = in.SurchargeCents
+	pp.TaxCents = in.TaxCents
+	pp.Items = itemsJSON
+	pp.PaymentTypesAllowed = in.PaymentTypesAllowed
+	pp.PublicToken = in.PublicToken
+	pp.ApplePayMid = in.ApplePayMid
+	pp.GooglePayMid = in.GooglePayMid
+	pp.FeatureGraphic = in.FeatureGraphic
+	pp.Logo = in.Logo
+	pp.Logo2 = in.Logo2
+	pp.FavIcon = in.FavIcon
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/config"
+	"vitalink/internal/models"
+)
+
+const (
+	defaultPageListLimit = 50
+	maxPageListLimit     = 200
+)
+
+// pageCursor marks the last row of a page of results. Pages are ordered by
+// created_at then page_uid, newest first.
+type pageCursor struct {
+	CreatedAt time.Time
+	PageUID   string
+}
+
+func (pc pageCursor) encode() string {
+	raw := pc.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + pc.PageUID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	ts, uid, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	return pageCursor{CreatedAt: t, PageUID: uid}, nil
+}
+
+func handleListPaymentPages(c echo.Context, db *gorm.DB) error {
+	q := db.Model(&models.PaymentPage{}).Where("merchant_id = ?", authedMerchant(c))
+
+	if v := c.QueryParam("status"); v != "" {
+		q = q.Where("status IN ?", strings.Split(v, ","))
+	}
+	if v := c.QueryParam("invoice_no"); v != "" {
+		q = q.Where("invoice_no = ?", v)
+	}
+	for param, cond := range map[string]string{"created_from": "created_at >= ?", "created_to": "created_at < ?"} {
+		if v := c.QueryParam(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]any{"error": param + " must be an RFC 3339 timestamp"})
+			}
+			q = q.Where(cond, t)
+		}
+	}
+	for param, cond := range map[string]string{"amount_min": "amount_cents >= ?", "amount_max": "amount_cents <= ?"} {
+		if v := c.QueryParam(param); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]any{"error": param + " must be an integer number of cents"})
+			}
+			q = q.Where(cond, n)
+		}
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		cur, err := decodePageCursor(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid cursor"})
+		}
+		q = q.Where("(created_at < ?) OR (created_at = ? AND page_uid < ?)", cur.CreatedAt, cur.CreatedAt, cur.PageUID)
+	}
+
+	limit := defaultPageListLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": "limit must be a positive integer"})
+		}
+		limit = min(n, maxPageListLimit)
+	}
+
+	// Fetch one extra row to learn whether there is a next page.
+	var pages []models.PaymentPage
+	if err := q.Order("created_at DESC, page_uid DESC").Limit(limit + 1).Find(&pages).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+
+	nextCursor := ""
+	if len(pages) > limit {
+		pages = pages[:limit]
+		last := pages[len(pages)-1]
+		nextCursor = pageCursor{CreatedAt: last.CreatedAt, PageUID: last.PageUID}.encode()
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"data":        pages,
+		"next_cursor": nextCursor,
+	})
+}
+
+func handleGetPaymentPage(c echo.Context, db *gorm.DB) error {
+	var pp models.PaymentPage
+	err := db.First(&pp, "merchant_id = ? AND page_uid = ?", c.Param("merchant_id"), c.Param("page_uid")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+
+	var txs []models.Transaction
+	if err := db.Order("created_at ASC, id ASC").
+		Find(&txs, "merchant_id = ? AND page_uid = ?", pp.MerchantID, pp.PageUID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"data": map[string]any{
+			"page":         pp,
+			"transactions": txs,
+		},
+	})
+}
+
+func handleUpdatePaymentPage(c echo.Context, cfg *config.Config, db *gorm.DB) error {
+	var pp models.PaymentPage
+	err := db.First(&pp, "merchant_id = ? AND page_uid = ?", c.Param("merchant_id"), c.Param("page_uid")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	// A page past its ExpireAt is expired even if the sweeper has not got to
+	// it yet.
+	if pp.Status == models.StatusOpen && pp.IsExpired(time.Now()) {
+		expirePaymentPage(db, &pp)
+		return c.JSON(http.StatusGone, map[string]any{"error": "payment page has expired", "status": models.StatusExpired})
+	}
+	if pp.Status == models.StatusExpired {
+		return c.JSON(http.StatusGone, map[string]any{"error": "payment page has expired", "status": pp.Status})
+	}
+	if pp.Status != models.StatusOpen {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "only open payment pages can be updated", "status": pp.Status})
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid request"})
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	req := inputFromPage(&pp)
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+	if req.MerchantID != pp.MerchantID || req.PageUID != pp.PageUID {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "merchant_id and page_uid cannot be changed"})
+	}
+	// An itemized amount follows the items, tax and fees unless the patch
+	// sets it, so editing any of them does not leave the stored total behind.
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(body, &fields)
+	if _, ok := fields["amount_cents"]; !ok {
+		if items, err := models.ParseItems(req.Items); err == nil && len(items) > 0 {
+			req.AmountCents = 0
+		}
+	}
+	itemsJSON, err := req.normalize(cfg.Pages)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+	req.apply(&pp, itemsJSON)
+
+	// The status condition keeps a charge that started meanwhile from seeing a
+	// different amount than the customer was shown.
+	res := db.Model(&models.PaymentPage{}).
+		Where("merchant_id = ? AND page_uid = ? AND status = ?", pp.MerchantID, pp.PageUID, models.StatusOpen).
+		Select(append([]string{"updated_at"}, editablePageColumns...)).
+		Updates(&pp)
+	if res.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "update failed", "details": res.Error.Error()})
+	}
+	if res.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "only open payment pages can be updated"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"data": pp})
+}
+
+func handleCancelPaymentPage(c echo.Context, db *gorm.DB) error {
+	var pp models.PaymentPage
+	err := db.First(&pp, "merchant_id = ? AND page_uid = ?", c.Param("merchant_id"), c.Param("page_uid")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+
+	if err := pp.Transition(db, models.StatusCancelled, nil); err != nil {
+		var terr *models.TransitionError
+		if errors.As(err, &terr) || errors.Is(err, models.ErrStatusConflict) {
+			return c.JSON(http.StatusConflict, map[string]any{"error": "only open payment pages can be cancelled", "status": pp.Status})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": pp})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"vitalink/internal/config"
+	"vitalink/internal/models"
+)
+
+func TestUpdateExpiredPaymentPage(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+	past := time.Now().Add(-time.Minute)
+	if err := db.Model(page).Update("expire_at", past).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"amount_cents":500}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("merchant_id", "page_uid")
+	c.SetParamValues(page.MerchantID, page.PageUID)
+	if err := handleUpdatePaymentPage(c, config.Default(), db); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusGone {
+		t.Errorf("status code = %d, want 410: %s", rec.Code, rec.Body)
+	}
+
+	var got models.PaymentPage
+	if err := db.First(&got, "merchant_id = ? AND page_uid = ?", page.MerchantID, page.PageUID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.StatusExpired || got.AmountCents != page.AmountCents {
+		t.Errorf("page = %s %d, want expired and unchanged", got.Status, got.AmountCents)
+	}
+}
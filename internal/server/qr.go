@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+	"vitalink/internal/netguard"
+	"vitalink/internal/qr"
+)
+
+// maxLogoBytes and maxLogoSide bound the merchant logo fetched for a QR code,
+// the second so a small file cannot decode to a huge image.
+const (
+	maxLogoBytes = 2 << 20
+	maxLogoSide  = 2048
+)
+
+// logoClient fetches merchant-supplied logo URLs. Its dialer refuses any
+// address that is not public, checked after DNS resolution, so neither a
+// hostname nor a redirect can point it at this host or the internal network.
+var logoClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: netguard.PublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("logo redirected to non-https URL %s", req.URL)
+		}
+		if len(via) >= 3 {
+			return errors.New("logo: too many redirects")
+		}
+		return nil
+	},
+}
+
+// qrOptions reads the rendering options from the query string:
+//
+//	size    pixels, 64-2048 (default 256)
+//	level   error correction L, M, Q or H (default M; H with a logo)
+//	fg, bg  hex colors, with or without '#'
+//	margin  quiet zone in modules, 0-16 (default 4)
+//	format  png, svg or pdf
+//	layout  "tent" for a printable table tent (pdf or svg)
+//	logo    1 to put the merchant logo in the middle
+func qrOptions(c echo.Context) (qr.Options, bool, error) {
+	opts := qr.DefaultOptions()
+	if q := c.QueryParam("size"); q != "" {
+		if v, err := strconv.Atoi(q); err == nil && v >= 64 && v <= 2048 {
+			opts.Size = v
+		}
+	}
+	if q := c.QueryParam("level"); q != "" {
+		level, err := qr.ParseLevel(q)
+		if err != nil {
+			return opts, false, err
+		}
+		opts.Level = level
+	}
+	if q := c.QueryParam("fg"); q != "" {
+		col, err := qr.ParseColor(q)
+		if err != nil {
+			return opts, false, fmt.Errorf("fg: %w", err)
+		}
+		opts.Foreground = col
+	}
+	if q := c.QueryParam("bg"); q != "" {
+		col, err := qr.ParseColor(q)
+		if err != nil {
+			return opts, false, fmt.Errorf("bg: %w", err)
+		}
+		opts.Background = col
+	}
+	if q := c.QueryParam("margin"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v < 0 || v > 16 {
+			return opts, false, errors.New("margin must be between 0 and 16")
+		}
+		opts.QuietZone = v
+	}
+
+	opts.Layout = strings.ToLower(c.QueryParam("layout"))
+	if opts.Layout != qr.LayoutCode && opts.Layout != qr.LayoutTent {
+		return opts, false, errors.New("layout must be tent or omitted")
+	}
+	opts.Format = strings.ToLower(c.QueryParam("format"))
+	switch {
+	case opts.Format == "" && opts.Layout == qr.LayoutTent:
+		opts.Format = qr.FormatPDF
+	case opts.Format == "":
+		opts.Format = qr.FormatPNG
+	case opts.Format != qr.FormatPNG && opts.Format != qr.FormatSVG && opts.Format != qr.FormatPDF:
+		return opts, false, errors.New("format must be png, svg or pdf")
+	}
+	if opts.Layout == qr.LayoutTent && opts.Format == qr.FormatPNG {
+		return opts, false, errors.New("the tent layout is available as pdf or svg")
+	}
+	if opts.Layout == qr.LayoutCode && opts.Format == qr.FormatPDF {
+		return opts, false, errors.New("pdf output requires layout=tent")
+	}
+
+	logo, _ := strconv.ParseBool(c.QueryParam("logo"))
+	return opts, logo, nil
+}
+
+// handleQRPaymentPage serves the code for an existing, payable page.
+// Rendered codes are cached by page URL and options; the page itself is
+// looked up on every request so a cancelled or expired page stops serving.
+func handleQRPaymentPage(c echo.Context, db *gorm.DB, cache *qr.Cache) error {
+	merchantID := c.Param("merchant_id")
+	pageUID := c.Param("page_uid")
+
+	scheme := "https"
+	if c.Scheme() != "" {
+		scheme = c.Scheme()
+	}
+	host := c.Request().Host
+	pageURL := scheme + "://" + host + "/p/" + merchantID + "/" + pageUID
+
+	opts, withLogo, err := qrOptions(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+
+	var pp models.PaymentPage
+	err = db.First(&pp, "merchant_id = ? AND page_uid = ?", merchantID, pageUID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	// Only a page that can still be paid, or is being paid, gets a code.
+	if (pp.Status != models.StatusOpen && pp.Status != models.StatusProcessing) || pp.IsExpired(time.Now()) {
+		return c.JSON(http.StatusGone, map[string]any{"error": "payment page is no longer available", "status": pp.Status})
+	}
+
+	logoURL := ""
+	if withLogo {
+		merchant, err := loadMerchant(db, merchantID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+		}
+		logoURL = models.ThemeFor(&pp, merchant).Logo
+		if logoURL == "" {
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": "no logo is configured for this page or merchant"})
+		}
+	}
+	if opts.Layout == qr.LayoutTent {
+		cat := pageCatalog(c, pp.Locale)
+		opts.Title = pp.StoreName
+		if opts.Title == "" {
+			opts.Title = pp.Title
+		}
+		opts.Amount = formatMoney(cat, pp.AmountCents, pp.Currency)
+		opts.Caption = cat.T("qr.scan_to_pay")
+	}
+
+	key := fmt.Sprintf("%s|%d|%d|%v|%v|%d|%s|%s|%s|%s|%s|%s", pageURL, opts.Level, opts.Size,
+		opts.Foreground, opts.Background, opts.QuietZone, opts.Format, opts.Layout, logoURL, opts.Title, opts.Amount, opts.Caption)
+	img, ok := cache.Get(key)
+	if !ok {
+		if logoURL != "" {
+			if opts.Logo, err = fetchLogo(c.Request().Context(), logoURL); err != nil {
+				log.Println("QR logo fetch failed:", err)
+				return c.JSON(http.StatusBadGateway, map[string]any{"error": "could not load logo"})
+			}
+		}
+		body, contentType, err := qr.Render(pageURL, opts)
+		if err != nil {
+			log.Println("QR render failed:", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		img = qr.NewImage(body, contentType)
+		cache.Add(key, img)
+	}
+
+	h := c.Response().Header()
+	h.Set("ETag", img.ETag)
+	h.Set("Cache-Control", "public, max-age=300")
+	if opts.Layout == qr.LayoutTent {
+		h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%s.%s"`, merchantID, pageUID, opts.Format))
+	}
+	if etagMatch(c.Request().Header.Get("If-None-Match"), img.ETag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, img.ContentType, img.Body)
+}
+
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchLogo downloads and decodes a PNG, JPEG or GIF logo from an https URL
+// on a public address.
+func fetchLogo(ctx context.Context, logoURL string) (image.Image, error) {
+	u, err := url.Parse(logoURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("logo %q is not an https URL", logoURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := logoClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("logo %s: status %d", logoURL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLogoBytes))
+	if err != nil {
+		return nil, err
+	}
+	return decodeLogo(body)
+}
+
+// decodeLogo decodes img after checking its dimensions from the header.
+func decodeLogo(img []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width > maxLogoSide || cfg.Height > maxLogoSide {
+		return nil, fmt.Errorf("logo is %dx%d, larger than %dx%d", cfg.Width, cfg.Height, maxLogoSide, maxLogoSide)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(img))
+	return decoded, err
+}
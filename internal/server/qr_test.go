@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestEtagMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`*`, true},
+		{`"abcd"`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeLogo(t *testing.T) {
+	img, err := decodeLogo(encodePNG(t, 64, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("bounds = %v, want 64x32", b)
+	}
+
+	if _, err := decodeLogo(encodePNG(t, maxLogoSide+1, 1)); err == nil {
+		t.Error("oversized logo decoded, want error")
+	}
+	if _, err := decodeLogo([]byte("<svg/>")); err == nil {
+		t.Error("non-image decoded, want error")
+	}
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+)
+
+const reconcileBatchSize = 100
+
+// chargeKinds are the ledger kinds a checkout writes.
+var chargeKinds = []string{models.TransactionSale, models.TransactionAuthorization}
+
+// followUpKinds are the ledger kinds written against a page after checkout.
+var followUpKinds = []string{models.TransactionRefund, models.TransactionVoid, models.TransactionCapture}
+
+// RunChargeReconciler settles charge attempts whose outcome was never
+// recorded, checking every interval until ctx is cancelled. Attempts and
+// pages are only touched once they are older than after, which must exceed
+// the gateway timeout so live requests are left alone.
+func RunChargeReconciler(ctx context.Context, db *gorm.DB, gateways gateway.Resolver, interval, after, callTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reconcileCharges(ctx, db, gateways, time.Now().Add(-after), callTimeout)
+		}
+	}
+}
+
+func reconcileCharges(ctx context.Context, db *gorm.DB, gateways gateway.Resolver, cutoff time.Time, callTimeout time.Duration) {
+	// Attempts still pending: the gateway's answer was lost.
+	var pending []models.Transaction
+	if err := db.WithContext(ctx).
+		Where("status = ? AND kind IN ? AND created_at < ?", models.TransactionPending, chargeKinds, cutoff).
+		Order("id ASC").
+		Limit(reconcileBatchSize).
+		Find(&pending).Error; err != nil {
+		log.Println("Error finding pending charge attempts:", err)
+		return
+	}
+
+	// Pages still processing whose attempt did get an answer, but the page
+	// was never moved on from it.
+	var stuck []models.PaymentPage
+	if err := db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", models.StatusProcessing, cutoff).
+		Order("updated_at ASC").
+		Limit(reconcileBatchSize).
+		Find(&stuck).Error; err != nil {
+		log.Println("Error finding stuck payment pages:", err)
+		return
+	}
+
+	// Shutdown stops the pass between attempts, never halfway through one.
+	detached := context.WithoutCancel(ctx)
+	for _, t := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := reconcileAttempt(detached, db, gateways, t.ID, callTimeout); err != nil {
+			log.Println("Error reconciling charge attempt:", t.MerchantID, t.PageUID, t.ID, err)
+		}
+	}
+	for _, p := range stuck {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := reconcilePage(detached, db, p.MerchantID, p.PageUID); err != nil {
+			log.Println("Error reconciling payment page:", p.MerchantID, p.PageUID, err)
+		}
+	}
+
+	flagLostFollowUps(ctx, db, cutoff)
+}
+
+// flagLostFollowUps flags refunds, voids and captures whose answer was lost. They stay
+// pending so their amount stays held and they cannot be repeated; the gateway
+// can only be asked about charges, so a person has to settle them.
+func flagLostFollowUps(ctx context.Context, db *gorm.DB, cutoff time.Time) {
+	var lost []models.Transaction
+	if err := db.WithContext(ctx).
+		Where("status = ? AND kind IN ? AND created_at < ? AND reconciled_at IS NULL",
+			models.TransactionPending, followUpKinds, cutoff).
+		Order("id ASC").
+		Limit(reconcileBatchSize).
+		Find(&lost).Error; err != nil {
+		log.Println("Error finding pending follow-up attempts:", err)
+		return
+	}
+	for i := range lost {
+		t := &lost[i]
+		note := fmt.Sprintf("outcome of this %s is unknown; check the processor and settle it by hand", t.Kind)
+		if err := flag(db, t, note); err != nil {
+			log.Println("Error flagging follow-up attempt:", t.MerchantID, t.PageUID, t.ID, err)
+		}
+	}
+}
+
+// reconcileAttempt asks the gateway what became of a pending attempt, records
+// the answer and brings the page in line with it. The page lock is only held
+// while reading and writing; the gateway is called with it released, and the
+// attempt is checked to still be pending each time the lock is taken again.
+func reconcileAttempt(ctx context.Context, db *gorm.DB, gateways gateway.Resolver, id uint, callTimeout time.Duration) error {
+	var page *models.PaymentPage
+	var pending *models.Transaction
+	err := lockAttempt(ctx, db, id, func(tx *gorm.DB, p *models.PaymentPage, t *models.Transaction) error {
+		// Without a RefNo the gateway is asked about the page, and would answer
+		// about the later attempt instead.
+		var later int64
+		tx.Model(&models.Transaction{}).
+			Where("merchant_id = ? AND page_uid = ? AND kind IN ? AND id > ?", t.MerchantID, t.PageUID, chargeKinds, t.ID).
+			Count(&later)
+		if later > 0 && t.RefNo == "" {
+			t.Status = models.TransactionError
+			t.Error = "outcome lost and superseded by a later attempt"
+			return flag(tx, t, "outcome of this attempt is unknown; check the processor for a second charge")
+		}
+		page, pending = p, t
+		return nil
+	})
+	if err != nil || pending == nil {
+		return err
+	}
+
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return errors.New("no payment gateway configured")
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	res, err := gw.Lookup(lookupCtx, gateway.LookupRequest{
+		RefNo:      pending.RefNo,
+		Currency:   pending.Currency,
+		MerchantID: page.MerchantID,
+		PageUID:    page.PageUID,
+		InvoiceNo:  page.InvoiceNo,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("lookup: %w", err)
+	}
+	// A server error says nothing about the charge; try again next pass.
+	if res.HTTPStatus >= 500 {
+		return fmt.Errorf("lookup: gateway answered %d: %s", res.HTTPStatus, res.Message)
+	}
+
+	var release *models.Transaction
+	err = lockAttempt(ctx, db, id, func(tx *gorm.DB, page *models.PaymentPage, t *models.Transaction) error {
+		applyResult(t, res, nil)
+		voidIt, err := resolveAttempt(tx, page, t, res)
+		if voidIt {
+			release = t
+		}
+		return err
+	})
+	if err != nil || release == nil {
+		return err
+	}
+
+	// The void gets its own timeout rather than what the lookup left. If it
+	// fails the attempt stays pending and the next pass tries again.
+	voidCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	if err := voidAttempt(voidCtx, db, gw, page, release); err != nil {
+		return err
+	}
+	return lockAttempt(ctx, db, id, func(tx *gorm.DB, page *models.PaymentPage, t *models.Transaction) error {
+		applyResult(t, res, nil)
+		return releasedAttempt(tx, page, t)
+	})
+}
+
+// lockAttempt runs fn in a transaction holding the lock on the page of
+// attempt id, with the attempt read under it. fn is skipped if the attempt
+// has been settled since it was listed.
+func lockAttempt(ctx context.Context, db *gorm.DB, id uint, fn func(tx *gorm.DB, page *models.PaymentPage, t *models.Transaction) error) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var t models.Transaction
+		if err := tx.First(&t, id).Error; err != nil {
+			return err
+		}
+		page, err := lockPaymentPage(tx, t.MerchantID, t.PageUID)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&t, id).Error; err != nil || t.Status != models.TransactionPending {
+			return err
+		}
+		return fn(tx, page, &t)
+	})
+}
+
+// reconcilePage finishes a page left in "processing" by an attempt that did
+// get an answer from the gateway.
+func reconcilePage(ctx context.Context, db *gorm.DB, merchantID, pageUID string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		page, err := lockPaymentPage(tx, merchantID, pageUID)
+		if err != nil {
+			return err
+		}
+		if page.Status != models.StatusProcessing {
+			return nil
+		}
+
+		var t models.Transaction
+		err = tx.Order("id DESC").First(&t, "merchant_id = ? AND page_uid = ? AND kind IN ?",
+			page.MerchantID, page.PageUID, chargeKinds).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// The process stopped before the attempt was written, so the
+			// gateway was never called.
+			log.Println("Reopening payment page with no charge attempt:", page.MerchantID, page.PageUID)
+			return page.Transition(tx, models.StatusOpen, nil)
+		case err != nil:
+			return err
+		case t.Status == models.TransactionPending:
+			// Left for reconcileAttempt.
+			return nil
+		}
+
+		// The request already acted on the answer (voiding a partial approval,
+		// say) but could not move the page on.
+		if t.Status != models.TransactionApproved {
+			return page.Transition(tx, models.StatusOpen, nil)
+		}
+		if err := fulfillPage(tx, page, &gateway.Result{Approved: true, Last4: t.Last4, Brand: t.Brand}); err != nil {
+			return err
+		}
+		return flag(tx, &t, fmt.Sprintf("approved %s %s was not applied to the page; page finalized as %s",
+			t.Kind, t.RefNo, page.Status))
+	})
+}
+
+// resolveAttempt records the gateway's answer for a pending attempt and moves
+// the page to match it:
+//
+//   - approved for the full amount on a processing page: the page is finalized;
+//   - approved for less, or on a page no longer waiting for it: nothing is
+//     written and it reports true; the caller voids the charge once the lock
+//     is released and finishes with releasedAttempt;
+//   - not approved: a processing page is reopened.
+//
+// Anything a person should look at is flagged on the attempt.
+func resolveAttempt(tx *gorm.DB, page *models.PaymentPage, t *models.Transaction, res *gateway.Result) (bool, error) {
+	processing := page.Status == models.StatusProcessing
+	ours := page.Status == fulfilledStatus(page) && !paidByOther(tx, page, t.ID)
+
+	switch {
+	case res.Approved && processing && !res.Partial(t.AmountCents):
+		if err := fulfillPage(tx, page, res); err != nil {
+			return false, err
+		}
+		return false, flag(tx, t, fmt.Sprintf("gateway approved %s %s that was never recorded; page finalized as %s",
+			t.Kind, t.RefNo, page.Status))
+
+	case res.Approved && ours:
+		// The page was finalized from this attempt; only the ledger write was lost.
+
+	case res.Approved:
+		return true, nil
+
+	case processing:
+		if err := page.Transition(tx, models.StatusOpen, nil); err != nil {
+			return false, err
+		}
+
+	case ours:
+		return false, flag(tx, t, fmt.Sprintf("page is %s but gateway reports %q for its charge: %s",
+			page.Status, res.Status, res.Message))
+	}
+
+	now := time.Now()
+	t.ReconciledAt = &now
+	return false, tx.Save(t).Error
+}
+
+// releasedAttempt records an approved attempt whose charge has been voided as
+// TransactionVoided, reopening the page if it was still waiting on it.
+func releasedAttempt(tx *gorm.DB, page *models.PaymentPage, t *models.Transaction) error {
+	was := page.Status
+	if was == models.StatusProcessing {
+		if err := page.Transition(tx, models.StatusOpen, nil); err != nil {
+			return err
+		}
+	}
+	t.Status = models.TransactionVoided
+	return flag(tx, t, fmt.Sprintf("gateway approved %d of %d for %s %s while page was %s; charge voided",
+		saleAmountCents(t), t.AmountCents, t.Kind, t.RefNo, was))
+}
+
+// flag saves t as reconciled with a discrepancy for a person to review.
+func flag(tx *gorm.DB, t *models.Transaction, note string) error {
+	now := time.Now()
+	t.ReconciledAt = &now
+	t.Discrepancy = note
+	log.Println("Reconciliation discrepancy:", t.MerchantID, t.PageUID, note)
+	return tx.Save(t).Error
+}
+
+// paidByOther reports whether an approved charge other than id is on file for
+// the page.
+func paidByOther(tx *gorm.DB, page *models.PaymentPage, id uint) bool {
+	var n int64
+	tx.Model(&models.Transaction{}).
+		Where("merchant_id = ? AND page_uid = ? AND kind IN ? AND status = ? AND id <> ?",
+			page.MerchantID, page.PageUID, chargeKinds, models.TransactionApproved, id).
+		Count(&n)
+	return n > 0
+}
+
+// voidAttempt releases the charge t made. The void is on the ledger as
+// pending before the gateway is called, like any other. A void the gateway
+// refuses is an error, so the attempt is retried on the next pass rather than
+// marked done.
+func voidAttempt(ctx context.Context, db *gorm.DB, gw gateway.PaymentGateway, page *models.PaymentPage, t *models.Transaction) error {
+	void, err := beginTransaction(db, page, models.TransactionVoid, saleAmountCents(t), 0)
+	if err != nil {
+		return fmt.Errorf("void: %w", err)
+	}
+	res, err := gw.Void(ctx, gateway.VoidRequest{
+		RefNo:      t.RefNo,
+		MerchantID: page.MerchantID,
+		PageUID:    page.PageUID,
+		InvoiceNo:  page.InvoiceNo,
+	})
+	settleTransaction(db, void, res, err)
+	if err != nil {
+		return fmt.Errorf("void: %w", err)
+	}
+	if !res.Approved {
+		return fmt.Errorf("void declined: %s", res.Message)
+	}
+	return nil
+}
+
+// handleListDiscrepancies returns the attempts the reconciler flagged for the
+// authenticated merchant, newest first.
+func handleListDiscrepancies(c echo.Context, db *gorm.DB) error {
+	var txs []models.Transaction
+	if err := db.Order("reconciled_at DESC, id DESC").
+		Limit(maxPageListLimit).
+		Find(&txs, "merchant_id = ? AND discrepancy <> ''", authedMerchant(c)).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": txs})
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+)
+
+func TestReleasedAttemptIsNotAPayment(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+	if err := page.Transition(db, models.StatusProcessing, nil); err != nil {
+		t.Fatal(err)
+	}
+	paid := recordTransaction(db, page, models.TransactionSale, 1000, 0, &gateway.Result{Approved: true, AuthorizedCents: 1000, RefNo: "R1"}, nil)
+	if err := page.Transition(db, models.StatusPaid, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A second charge whose approval came back after the page was paid.
+	late := recordTransaction(db, page, models.TransactionSale, 1000, 0, &gateway.Result{Approved: true, AuthorizedCents: 1000, RefNo: "R2"}, nil)
+
+	if err := releasedAttempt(db, page, late); err != nil {
+		t.Fatal(err)
+	}
+	if late.Status != models.TransactionVoided || late.Discrepancy == "" {
+		t.Errorf("released attempt = %s %q, want voided and flagged", late.Status, late.Discrepancy)
+	}
+	if paidByOther(db, page, paid.ID) {
+		t.Error("released attempt still counts as paying for the page")
+	}
+	sale, err := settledSale(db, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sale.ID != paid.ID {
+		t.Errorf("settled sale = %d, want %d", sale.ID, paid.ID)
+	}
+}
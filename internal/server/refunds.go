@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+	"vitalink/internal/webhooks"
+)
+
+// lockPaymentPage loads a page inside tx with a row lock so concurrent refunds
+// and voids against the same page are serialized.
+func lockPaymentPage(tx *gorm.DB, merchantID, pageUID string) (*models.PaymentPage, error) {
+	var page models.PaymentPage
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&page, "merchant_id = ? AND page_uid = ?", merchantID, pageUID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// settledSale returns the approved sale or capture that paid for the page.
+func settledSale(db *gorm.DB, page *models.PaymentPage) (*models.Transaction, error) {
+	var sale models.Transaction
+	err := db.Order("id DESC").First(&sale,
+		"merchant_id = ? AND page_uid = ? AND kind IN ? AND status = ?",
+		page.MerchantID, page.PageUID, []string{models.TransactionSale, models.TransactionCapture}, models.TransactionApproved).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sale, nil
+}
+
+func saleAmountCents(sale *models.Transaction) int64 {
+	if sale.AuthorizedCents > 0 {
+		return sale.AuthorizedCents
+	}
+	return sale.AmountCents
+}
+
+func handleRefundPayment(c echo.Context, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver) error {
+	var req struct {
+		AmountCents int64 `json:"amount_cents"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid request"})
+	}
+	if req.AmountCents < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "amount_cents must be >= 0"})
+	}
+
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	page, err := lockPaymentPage(tx, c.Param("merchant_id"), c.Param("page_uid"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if page.Status != models.StatusPaid && page.Status != models.StatusPartiallyRefunded {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "payment page is not refundable", "status": page.Status})
+	}
+
+	sale, err := settledSale(tx, page)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "no settled sale found for payment page"})
+	}
+	voids, _, err := pendingAttempts(tx, page, models.TransactionVoid)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if voids > 0 {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "a void is in progress for this payment page"})
+	}
+	// Refunds still waiting on the gateway hold their amount.
+	_, reserved, err := pendingAttempts(tx, page, models.TransactionRefund)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	remaining := saleAmountCents(sale) - page.RefundedCents - reserved
+	amount := req.AmountCents
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "refund exceeds remaining amount", "remaining_cents": remaining})
+	}
+	if amount < 1 {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "nothing left to refund"})
+	}
+
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "no payment gateway configured"})
+	}
+
+	// The refund goes on the ledger, and the page lock is released, before
+	// the gateway is called.
+	txn, err := beginTransaction(tx, page, models.TransactionRefund, amount, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "could not record refund attempt", "details": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "transaction commit failed", "details": err.Error()})
+	}
+	committed = true
+
+	ctx, cancel := gatewayContext(c, cfg)
+	defer cancel()
+
+	res, err := gw.Refund(ctx, gateway.RefundRequest{
+		RefNo:       sale.RefNo,
+		AmountCents: amount,
+		Currency:    page.Currency,
+		MerchantID:  page.MerchantID,
+		PageUID:     page.PageUID,
+		InvoiceNo:   page.InvoiceNo,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("Gateway timed out, leaving refund pending:", page.MerchantID, page.PageUID, txn.ID, err)
+		return c.JSON(http.StatusGatewayTimeout, map[string]any{"error": "refund outcome unknown, it stays pending until confirmed", "details": err.Error()})
+	}
+
+	var newStatus models.PageStatus
+	page, serr := settleFollowUp(db, txn, res, err, func(tx *gorm.DB, page *models.PaymentPage) error {
+		refunded := page.RefundedCents + amount
+		newStatus = models.StatusPartiallyRefunded
+		if refunded >= saleAmountCents(sale) {
+			newStatus = models.StatusRefunded
+		}
+		if err := page.Transition(tx, newStatus, map[string]any{"refunded_cents": refunded}); err != nil {
+			return err
+		}
+		page.RefundedCents = refunded
+		webhooks.Emit(tx, page.MerchantID, webhooks.EventRefundSucceeded, map[string]any{
+			"page":        page,
+			"transaction": txn,
+		})
+		return nil
+	})
+	if serr != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "refund outcome could not be recorded, it stays pending until confirmed", "details": serr.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]any{"error": "refund request failed", "details": err.Error()})
+	}
+	if !res.Approved {
+		status := http.StatusBadRequest
+		if res.HTTPStatus >= 400 {
+			status = res.HTTPStatus
+		}
+		return c.JSON(status, map[string]any{"approved": false, "message": res.Message})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"approved":       true,
+		"message":        res.Message,
+		"status":         newStatus,
+		"refunded_cents": page.RefundedCents,
+	})
+}
+
+func handleVoidPayment(c echo.Context, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver) error {
+	tx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	page, err := lockPaymentPage(tx, c.Param("merchant_id"), c.Param("page_uid"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "payment page not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if page.Status != models.StatusPaid || page.RefundedCents > 0 {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "payment page cannot be voided", "status": page.Status})
+	}
+
+	sale, err := settledSale(tx, page)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "no settled sale found for payment page"})
+	}
+	inProgress, _, err := pendingAttempts(tx, page, models.TransactionRefund, models.TransactionVoid)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "db error"})
+	}
+	if inProgress > 0 {
+		return c.JSON(http.StatusConflict, map[string]any{"error": "a refund or void is in progress for this payment page"})
+	}
+
+	gw := gateways(page.MerchantID)
+	if gw == nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "no payment gateway configured"})
+	}
+
+	txn, err := beginTransaction(tx, page, models.TransactionVoid, saleAmountCents(sale), 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "could not record void attempt", "details": err.Error()})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "transaction commit failed", "details": err.Error()})
+	}
+	committed = true
+
+	ctx, cancel := gatewayContext(c, cfg)
+	defer cancel()
+
+	res, err := gw.Void(ctx, gateway.VoidRequest{
+		RefNo:      sale.RefNo,
+		MerchantID: page.MerchantID,
+		PageUID:    page.PageUID,
+		InvoiceNo:  page.InvoiceNo,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("Gateway timed out, leaving void pending:", page.MerchantID, page.PageUID, txn.ID, err)
+		return c.JSON(http.StatusGatewayTimeout, map[string]any{"error": "void outcome unknown, it stays pending until confirmed", "details": err.Error()})
+	}
+
+	_, serr := settleFollowUp(db, txn, res, err, func(tx *gorm.DB, page *models.PaymentPage) error {
+		return page.Transition(tx, models.StatusVoided, nil)
+	})
+	if serr != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "void outcome could not be recorded, it stays pending until confirmed", "details": serr.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]any{"error": "void request failed", "details": err.Error()})
+	}
+	if !res.Approved {
+		status := http.StatusBadRequest
+		if res.HTTPStatus >= 400 {
+			status = res.HTTPStatus
+		}
+		return c.JSON(status, map[string]any{"approved": false, "message": res.Message})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"approved": true,
+		"message":  res.Message,
+		"status":   models.StatusVoided,
+	})
+}
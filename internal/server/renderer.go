@@ -4,31 +4,144 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"strings"
+	"io/fs"
+	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"vitalink/internal/currency"
+	"vitalink/internal/i18n"
+	"vitalink/internal/models"
 )
 
+// TemplateRenderer keeps one parsed template set per locale so the t,
+// formatAmount and formatDate funcs are bound to that locale's catalog.
 type TemplateRenderer struct {
-	t *template.Template
+	fsys   fs.FS
+	reload bool
+
+	bundle *i18n.Bundle
+	sets   map[string]*template.Template
+}
+
+// NewRenderer parses templates/*.html and locales/*.json from fsys. With
+// reload set they are parsed again on every render instead.
+func NewRenderer(fsys fs.FS, reload bool) *TemplateRenderer {
+	r := &TemplateRenderer{fsys: fsys, reload: reload}
+	bundle, sets, err := r.load()
+	if err != nil {
+		panic(err)
+	}
+	r.bundle, r.sets = bundle, sets
+	return r
 }
 
-func NewRenderer() *TemplateRenderer {
-	funcs := template.FuncMap{
-		"formatAmount": func(cents int64, currency string) string {
-			major := float64(cents) / 100.0
-			s := sprintf("%.2f", major)
-			// s = strings.TrimRight(s, "0")
-			// s = strings.TrimRight(s, ".")
-			return strings.ToUpper(currency) + " $" + s
+func (r *TemplateRenderer) load() (*i18n.Bundle, map[string]*template.Template, error) {
+	bundle, err := i18n.Load(r.fsys, "locales")
+	if err != nil {
+		return nil, nil, err
+	}
+	sets := map[string]*template.Template{}
+	for _, locale := range bundle.Locales() {
+		cat, _ := bundle.Catalog(locale)
+		t, err := template.New("").Funcs(templateFuncs(cat)).ParseFS(r.fsys, "templates/*.html")
+		if err != nil {
+			return nil, nil, err
+		}
+		sets[locale] = t
+	}
+	return bundle, sets, nil
+}
+
+func templateFuncs(cat *i18n.Catalog) template.FuncMap {
+	return template.FuncMap{
+		"t":      cat.T,
+		"locale": func() string { return cat.Locale },
+		"formatAmount": func(minor int64, code string) string {
+			return formatMoney(cat, minor, code)
+		},
+		// formatDecimal is the unlocalized form, for inputs and wallet SDKs.
+		"formatDecimal": func(minor int64, code string) string {
+			return currency.Or(code).FormatDecimal(minor)
+		},
+		"formatDate": func(t time.Time) string {
+			return cat.Date(t.UTC())
+		},
+		"decimalSeparator": func() string { return cat.Decimal },
+		"groupSeparator":   func() string { return cat.Group },
+		"currencySymbol": func(code string) string {
+			return currency.Or(code).Symbol
+		},
+		"currencyExponent": func(code string) int {
+			return currency.Or(code).Exponent
 		},
 	}
-	t := template.Must(template.New("").Funcs(funcs).ParseGlob("templates/*.html"))
-	return &TemplateRenderer{t: t}
 }
 
+// Render picks the locale from the page being shown, falling back to the
+// request's Accept-Language header. Map data also gets a "theme" entry: the
+// page's merchant branding with the page's own assets taking precedence.
 func (r *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return r.t.ExecuteTemplate(w, name, data)
+	bundle, sets := r.bundle, r.sets
+	if r.reload {
+		var err error
+		if bundle, sets, err = r.load(); err != nil {
+			return fmt.Errorf("reload templates: %w", err)
+		}
+	}
+	cat := bundle.Match(pageLocale(data), c.Request().Header.Get("Accept-Language"))
+	c.Response().Header().Set("Content-Language", cat.Locale)
+	if m, ok := data.(map[string]any); ok {
+		data = withTheme(m)
+	}
+	return sets[cat.Locale].ExecuteTemplate(w, name, data)
 }
 
-func sprintf(format string, a ...any) string { return fmt.Sprintf(format, a...) }
+// formatMoney renders an amount for people, e.g. "USD $1,234.50" or
+// "EUR €1.234,50", using the catalog's separators.
+func formatMoney(cat *i18n.Catalog, minor int64, code string) string {
+	cur := currency.Or(code)
+	return cur.Code + " " + cur.Symbol + cat.Number(cur.FormatDecimal(minor))
+}
+
+// pageCatalog returns the catalog a page with pageLocale would be rendered
+// in for this request, for output produced outside of templates.
+func pageCatalog(c echo.Context, pageLocale string) *i18n.Catalog {
+	r, ok := c.Echo().Renderer.(*TemplateRenderer)
+	if !ok {
+		return &i18n.Catalog{Locale: i18n.DefaultLocale, Decimal: "."}
+	}
+	bundle := r.bundle
+	if r.reload {
+		if b, err := i18n.Load(r.fsys, "locales"); err == nil {
+			bundle = b
+		}
+	}
+	return bundle.Match(pageLocale, c.Request().Header.Get("Accept-Language"))
+}
+
+func pageLocale(data interface{}) string {
+	m, ok := data.(map[string]any)
+	if !ok {
+		return ""
+	}
+	if pp, ok := m["page"].(models.PaymentPage); ok {
+		return pp.Locale
+	}
+	return ""
+}
+
+func withTheme(data map[string]any) map[string]any {
+	var page *models.PaymentPage
+	if pp, ok := data["page"].(models.PaymentPage); ok {
+		page = &pp
+	}
+	merchant, _ := data["merchant"].(*models.Merchant)
+
+	view := make(map[string]any, len(data)+1)
+	for k, v := range data {
+		view[k] = v
+	}
+	view["theme"] = models.ThemeFor(page, merchant)
+	return view
+}
@@ -1,20 +1,62 @@
 package server
 
 import (
+	"io/fs"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
+
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
+	"vitalink/internal/qr"
 )
 
-func registerRoutes(e *echo.Echo, db *gorm.DB) {
-	e.Static("/.well-known", "public/.well-known")
-	e.File("/applePayIntegrationTest.html", "public/applePayIntegrationTest.html")
-	e.File("/", "public/index.html")
-	e.POST("/api/payment-pages", func(c echo.Context) error { return handleCreatePaymentPage(c, db) })
-	e.POST("/api/payments/:merchant_id/:page_uid/charge", func(c echo.Context) error { return handleChargePayment(c, db) })
-	e.GET("/api/payment-pages/:merchant_id/:page_uid/data", func(c echo.Context) error { return handleFetchPaymentPageData(c, db) })
+func registerRoutes(e *echo.Echo, cfg *config.Config, db *gorm.DB, gateways gateway.Resolver, assets fs.FS, inflight *Inflight) {
+	e.StaticFS("/.well-known", echo.MustSubFS(assets, "public/.well-known"))
+	e.FileFS("/applePayIntegrationTest.html", "public/applePayIntegrationTest.html", assets)
+	e.FileFS("/", "public/index.html", assets)
+
+	// Probes for the orchestrator: liveness, readiness and build info.
+	e.GET("/healthz", handleHealthz)
+	e.GET("/readyz", func(c echo.Context) error { return handleReadyz(c, cfg, db) })
+	e.GET("/version", handleVersion)
+
+	// Called from the customer's browser by payment.html, so not behind an API key.
+	// Routes that call the gateway are tracked by inflight so shutdown waits for them.
+	e.POST("/api/payments/:merchant_id/:page_uid/charge", func(c echo.Context) error { return handleChargePayment(c, cfg, db, gateways) }, inflight.Middleware)
+	e.GET("/api/payment-pages/:merchant_id/:page_uid/data", func(c echo.Context) error { return handleFetchPaymentPageData(c, cfg, db) })
+
+	api := e.Group("/api", apiKeyAuth(cfg, db))
+	api.POST("/payment-pages", func(c echo.Context) error { return handleCreatePaymentPage(c, cfg, db) })
+	api.GET("/payment-pages", func(c echo.Context) error { return handleListPaymentPages(c, db) })
+	api.GET("/payment-pages/:merchant_id/:page_uid", func(c echo.Context) error { return handleGetPaymentPage(c, db) })
+	api.PATCH("/payment-pages/:merchant_id/:page_uid", func(c echo.Context) error { return handleUpdatePaymentPage(c, cfg, db) })
+	api.POST("/payment-pages/:merchant_id/:page_uid/cancel", func(c echo.Context) error { return handleCancelPaymentPage(c, db) })
+	api.POST("/payments/:merchant_id/:page_uid/refund", func(c echo.Context) error { return handleRefundPayment(c, cfg, db, gateways) }, inflight.Middleware)
+	api.POST("/payments/:merchant_id/:page_uid/void", func(c echo.Context) error { return handleVoidPayment(c, cfg, db, gateways) }, inflight.Middleware)
+	api.POST("/payments/:merchant_id/:page_uid/capture", func(c echo.Context) error { return handleCapturePayment(c, cfg, db, gateways) }, inflight.Middleware)
+	api.POST("/payments/:merchant_id/:page_uid/void-auth", func(c echo.Context) error { return handleVoidAuthorization(c, cfg, db, gateways) }, inflight.Middleware)
+	api.GET("/payment-pages/:merchant_id/:page_uid/transactions", func(c echo.Context) error { return handleListTransactions(c, db) })
+	api.GET("/reconciliation/discrepancies", func(c echo.Context) error { return handleListDiscrepancies(c, db) })
+	api.POST("/settlements", func(c echo.Context) error { return handleImportSettlement(c, db) })
+	api.GET("/settlements", func(c echo.Context) error { return handleListSettlementRuns(c, db) })
+	api.GET("/settlements/:id", func(c echo.Context) error { return handleGetSettlementRun(c, db) })
+	api.GET("/settlements/:id/exceptions.csv", func(c echo.Context) error { return handleSettlementReport(c, db) })
+
+	api.POST("/webhooks/:merchant_id", func(c echo.Context) error { return handleCreateWebhook(c, db) })
+	api.GET("/webhooks/:merchant_id", func(c echo.Context) error { return handleListWebhooks(c, db) })
+	api.DELETE("/webhooks/:merchant_id/:id", func(c echo.Context) error { return handleDeleteWebhook(c, db) })
+	api.GET("/webhooks/:merchant_id/:id/deliveries", func(c echo.Context) error { return handleListWebhookDeliveries(c, db) })
+
+	api.POST("/api-keys", func(c echo.Context) error { return handleCreateAPIKey(c, db) })
+	api.GET("/api-keys", func(c echo.Context) error { return handleListAPIKeys(c, db) })
+	api.DELETE("/api-keys/:id", func(c echo.Context) error { return handleRevokeAPIKey(c, db) })
+
+	api.GET("/merchant", func(c echo.Context) error { return handleGetMerchant(c, db) })
+	api.PATCH("/merchant", func(c echo.Context) error { return handleUpdateMerchant(c, db) })
 
 	e.GET("/p/:merchant_id/:page_uid", func(c echo.Context) error { return handleViewPaymentPage(c, db) })
-	e.GET("/qr/:merchant_id/:page_uid", func(c echo.Context) error { return handleQRPaymentPage(c) })
+	qrCache := qr.NewCache(512)
+	e.GET("/qr/:merchant_id/:page_uid", func(c echo.Context) error { return handleQRPaymentPage(c, db, qrCache) })
 
 }
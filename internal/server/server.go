@@ -5,10 +5,21 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
 	"gorm.io/gorm"
+	"io/fs"
 	"net/http"
+
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
 )
 
-func Router(db *gorm.DB) *echo.Echo {
+// Assets is where templates, locales and public files are read from. Reload
+// re-parses templates on every render, for editing them against a disk FS.
+type Assets struct {
+	FS     fs.FS
+	Reload bool
+}
+
+func Router(cfg *config.Config, db *gorm.DB, gateways gateway.Resolver, assets Assets, inflight *Inflight) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
 	e.Logger.SetLevel(log.INFO)
@@ -18,7 +29,7 @@ func Router(db *gorm.DB) *echo.Echo {
 		Generator: func() string { return newUUID() },
 	}))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-    AllowOrigins: []string{"*"}, // allow any origin (development only!)
+    AllowOrigins: cfg.Server.CORSOrigins, // "*" only outside production
     AllowMethods: []string{
         http.MethodGet,
         http.MethodHead,
@@ -35,6 +46,7 @@ func Router(db *gorm.DB) *echo.Echo {
         echo.HeaderAuthorization,
         "X-Requested-With",
         "X-CSRF-Token",
+        headerIdempotencyKey,
     },
     ExposeHeaders: []string{
         echo.HeaderContentLength,
@@ -47,11 +59,13 @@ func Router(db *gorm.DB) *echo.Echo {
 
 	e.Use(middleware.Recover())
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		// Probes hit these every few seconds.
+		Skipper: func(c echo.Context) bool { return c.Path() == "/healthz" || c.Path() == "/readyz" },
 		Format: `${time_rfc3339} id=${id} remote_ip=${remote_ip} method=${method} uri=${uri} status=${status} latency=${latency_human} bytes_in=${bytes_in} bytes_out=${bytes_out} ua=${user_agent} error=${error}\n`,
 	}))
 
-	e.Renderer = NewRenderer()
+	e.Renderer = NewRenderer(assets.FS, assets.Reload)
 
-	registerRoutes(e, db)
+	registerRoutes(e, cfg, db, gateways, assets.FS, inflight)
 	return e
 }
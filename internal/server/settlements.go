@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+	"vitalink/internal/settlement"
+)
+
+// maxSettlementBytes bounds an uploaded settlement file.
+const maxSettlementBytes = 20 << 20
+
+// handleImportSettlement takes a processor settlement CSV, either as the
+// multipart field "file" or as the raw request body, and reconciles it.
+func handleImportSettlement(c echo.Context, db *gorm.DB) error {
+	var (
+		body     io.Reader
+		filename = c.QueryParam("filename")
+	)
+	if fh, err := c.FormFile("file"); err == nil {
+		if fh.Size > maxSettlementBytes {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{"error": "settlement file too large"})
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid upload"})
+		}
+		defer f.Close()
+		body = f
+		if filename == "" {
+			filename = fh.Filename
+		}
+	} else {
+		body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSettlementBytes)
+	}
+
+	run, exceptions, err := settlement.Import(db, authedMerchant(c), filename, body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{"error": "settlement file too large"})
+		case errors.Is(err, settlement.ErrInvalidFile):
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusCreated, map[string]any{"run": run, "exceptions": exceptions})
+}
+
+func handleListSettlementRuns(c echo.Context, db *gorm.DB) error {
+	var runs []models.SettlementRun
+	if err := db.Order("id DESC").Limit(maxPageListLimit).
+		Find(&runs, "merchant_id = ?", authedMerchant(c)).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": runs})
+}
+
+func handleGetSettlementRun(c echo.Context, db *gorm.DB) error {
+	run, exceptions, err := loadSettlementRun(c, db)
+	if run == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"run": run, "exceptions": exceptions})
+}
+
+// handleSettlementReport downloads a run's exceptions as CSV.
+func handleSettlementReport(c echo.Context, db *gorm.DB) error {
+	run, exceptions, err := loadSettlementRun(c, db)
+	if run == nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="settlement-%d-exceptions.csv"`, run.ID))
+	c.Response().WriteHeader(http.StatusOK)
+	return settlement.WriteReport(c.Response(), exceptions)
+}
+
+// loadSettlementRun fetches the run named by :id for the authenticated
+// merchant. When it returns a nil run the error response has been written and
+// err is the result of writing it.
+func loadSettlementRun(c echo.Context, db *gorm.DB) (*models.SettlementRun, []models.SettlementException, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, map[string]any{"error": "invalid id"})
+	}
+	var run models.SettlementRun
+	if err := db.First(&run, "id = ? AND merchant_id = ?", id, authedMerchant(c)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, c.JSON(http.StatusNotFound, map[string]any{"error": "settlement run not found"})
+		}
+		return nil, nil, c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	var exceptions []models.SettlementException
+	if err := db.Order("id ASC").Find(&exceptions, "run_id = ?", run.ID).Error; err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return &run, exceptions, nil
+}
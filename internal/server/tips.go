@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"vitalink/internal/models"
+)
+
+// parseTipPercentages reads AllowedTipPercentages, a JSON array of whole
+// percentages such as "[15,18,20]". An empty string means no presets.
+func parseTipPercentages(s string) ([]int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var pcts []int64
+	if err := json.Unmarshal([]byte(s), &pcts); err != nil {
+		return nil, errors.New("allowed_tip_percentages must be a JSON array of whole numbers, e.g. [15,18,20]")
+	}
+	for i, p := range pcts {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("allowed_tip_percentages[%d] must be between 1 and 100", i)
+		}
+	}
+	return pcts, nil
+}
+
+// presetTipCents is p percent of amountCents rounded half up, in integer math
+// so it matches calculateTipAmount in payment.html to the cent.
+func presetTipCents(amountCents, p int64) int64 {
+	return (amountCents*p + 50) / 100
+}
+
+// maxTipCents is the largest custom tip the page accepts. Without an explicit
+// MaxTipCents a tip may not exceed the page amount.
+func maxTipCents(page *models.PaymentPage) int64 {
+	if page.MaxTipCents > 0 {
+		return page.MaxTipCents
+	}
+	return page.AmountCents
+}
+
+// validateTip checks a tip sent by the browser against the page's settings. A
+// tip is accepted if it matches one of the preset percentages or is a custom
+// amount within maxTipCents.
+func validateTip(page *models.PaymentPage, tipCents int64) error {
+	if tipCents < 0 {
+		return errors.New("tip_amount_cents must be >= 0")
+	}
+	if tipCents == 0 {
+		return nil
+	}
+	if !page.IncludeTip {
+		return errors.New("tips are not enabled for this payment page")
+	}
+
+	pcts, err := parseTipPercentages(page.AllowedTipPercentages)
+	if err != nil {
+		return err
+	}
+	for _, p := range pcts {
+		if tipCents == presetTipCents(page.AmountCents, p) {
+			return nil
+		}
+	}
+	if tipCents > maxTipCents(page) {
+		return fmt.Errorf("tip_amount_cents must be at most %d", maxTipCents(page))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"vitalink/internal/models"
+)
+
+func TestValidateTip(t *testing.T) {
+	page := &models.PaymentPage{
+		AmountCents:           1999,
+		IncludeTip:            true,
+		AllowedTipPercentages: "[15,18,20]",
+	}
+	capped := *page
+	capped.MaxTipCents = 500
+	noTips := *page
+	noTips.IncludeTip = false
+	badPresets := *page
+	badPresets.AllowedTipPercentages = "[15,"
+	fractionalPresets := *page
+	fractionalPresets.AllowedTipPercentages = "[17.5]"
+	halfCent := *page
+	halfCent.AmountCents = 1050
+	halfCent.MaxTipCents = 100
+
+	tests := []struct {
+		name    string
+		page    *models.PaymentPage
+		tip     int64
+		wantErr bool
+	}{
+		{"no tip", page, 0, false},
+		{"negative", page, -1, true},
+		{"15 percent rounded", page, 300, false},
+		{"18 percent rounded", page, 360, false},
+		{"custom within amount", page, 1999, false},
+		{"custom over amount", page, 2000, true},
+		{"custom within max", &capped, 500, false},
+		{"custom over max", &capped, 501, true},
+		{"preset over max", &capped, 400, false},
+		{"tips disabled", &noTips, 100, true},
+		{"no tip with tips disabled", &noTips, 0, false},
+		{"bad presets", &badPresets, 100, true},
+		{"fractional presets", &fractionalPresets, 100, true},
+		{"preset rounds half up", &halfCent, 158, false},
+		{"preset rounded down", &halfCent, 157, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTip(tt.page, tt.tip)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTip(%d) = %v, want error %v", tt.tip, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPresetTipCents(t *testing.T) {
+	tests := []struct {
+		amount, pct, want int64
+	}{
+		{1999, 15, 300},
+		{1999, 18, 360},
+		{1050, 15, 158}, // 1050 * 0.15 is 157.49999... in floating point
+		{1, 20, 0},
+		{10, 25, 3},
+		{0, 20, 0},
+	}
+	for _, tt := range tests {
+		if got := presetTipCents(tt.amount, tt.pct); got != tt.want {
+			t.Errorf("presetTipCents(%d, %d) = %d, want %d", tt.amount, tt.pct, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+)
+
+// recordTransaction writes a ledger row for a gateway attempt. Exactly one of
+// res and callErr is expected to be set; tipCents is the share of amountCents
+// that is tip. Failures are logged, not returned, so a ledger hiccup never
+// changes the answer given to the customer.
+func recordTransaction(db *gorm.DB, page *models.PaymentPage, kind string, amountCents, tipCents int64, res *gateway.Result, callErr error) *models.Transaction {
+	t := newTransaction(page, kind, amountCents, tipCents)
+	applyResult(t, res, callErr)
+	if err := db.Create(t).Error; err != nil {
+		log.Println("Error recording transaction:", page.MerchantID, page.PageUID, kind, err)
+	}
+	return t
+}
+
+// beginTransaction writes a pending ledger row before the gateway is called,
+// so an attempt whose answer is lost still leaves a trace to reconcile. Unlike
+// recordTransaction it returns the error: a charge must not go out unrecorded.
+func beginTransaction(db *gorm.DB, page *models.PaymentPage, kind string, amountCents, tipCents int64) (*models.Transaction, error) {
+	t := newTransaction(page, kind, amountCents, tipCents)
+	t.Status = models.TransactionPending
+	if err := db.Create(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// settleTransaction records the gateway's answer on a pending row. Failures
+// are logged; the row stays pending and the reconciler picks it up.
+func settleTransaction(db *gorm.DB, t *models.Transaction, res *gateway.Result, callErr error) {
+	applyResult(t, res, callErr)
+	if err := db.Save(t).Error; err != nil {
+		log.Println("Error settling transaction:", t.MerchantID, t.PageUID, t.ID, err)
+	}
+}
+
+// settleFollowUp records the gateway's answer for a pending refund, void or
+// capture and, when it was approved, applies it to the page through apply,
+// all in one transaction against a freshly locked page. If that transaction
+// fails the attempt stays pending, still holding its amount, so a retry cannot
+// repeat it; the reconciler flags it for review.
+func settleFollowUp(db *gorm.DB, t *models.Transaction, res *gateway.Result, callErr error, apply func(tx *gorm.DB, page *models.PaymentPage) error) (*models.PaymentPage, error) {
+	var page *models.PaymentPage
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		page, err = lockPaymentPage(tx, t.MerchantID, t.PageUID)
+		if err != nil {
+			return err
+		}
+		applyResult(t, res, callErr)
+		if err := tx.Save(t).Error; err != nil {
+			return err
+		}
+		if callErr != nil || !res.Approved {
+			return nil
+		}
+		return apply(tx, page)
+	})
+	if err != nil {
+		log.Println("Error settling transaction, left pending:", t.MerchantID, t.PageUID, t.Kind, t.ID, err)
+	}
+	return page, err
+}
+
+// pendingAttempts counts the attempts of the given kinds on page that are
+// still waiting on the gateway, and sums the cents they hold.
+func pendingAttempts(db *gorm.DB, page *models.PaymentPage, kinds ...string) (count, cents int64, err error) {
+	var row struct {
+		Count int64
+		Cents int64
+	}
+	err = db.Model(&models.Transaction{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(amount_cents), 0) AS cents").
+		Where("merchant_id = ? AND page_uid = ? AND kind IN ? AND status = ?",
+			page.MerchantID, page.PageUID, kinds, models.TransactionPending).
+		Scan(&row).Error
+	return row.Count, row.Cents, err
+}
+
+func newTransaction(page *models.PaymentPage, kind string, amountCents, tipCents int64) *models.Transaction {
+	return &models.Transaction{
+		MerchantID:     page.MerchantID,
+		PageUID:        page.PageUID,
+		Kind:           kind,
+		AmountCents:    amountCents,
+		TipAmountCents: tipCents,
+		Currency:       page.Currency,
+	}
+}
+
+func applyResult(t *models.Transaction, res *gateway.Result, callErr error) {
+	switch {
+	case callErr != nil:
+		t.Status = models.TransactionError
+		t.Error = callErr.Error()
+	case res.Partial(t.AmountCents):
+		t.Status = models.TransactionPartial
+	case res.Approved:
+		t.Status = models.TransactionApproved
+	default:
+		t.Status = models.TransactionDeclined
+	}
+	if res != nil {
+		t.GatewayStatus = res.Status
+		t.RefNo = res.RefNo
+		t.AuthCode = res.AuthCode
+		t.AuthorizedCents = res.AuthorizedCents
+		t.Last4 = res.Last4
+		t.Brand = res.Brand
+		t.Message = res.Message
+		t.HTTPStatus = res.HTTPStatus
+		t.RawResponse = string(res.Raw)
+	}
+}
+
+func handleListTransactions(c echo.Context, db *gorm.DB) error {
+	merchantID := c.Param("merchant_id")
+	pageUID := c.Param("page_uid")
+
+	var txs []models.Transaction
+	if err := db.Order("created_at ASC, id ASC").
+		Find(&txs, "merchant_id = ? AND page_uid = ?", merchantID, pageUID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": txs})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"vitalink/internal/gateway"
+	"vitalink/internal/models"
+)
+
+// testDB connects to the database named by VITALINK_TEST_DATABASE_URL, which
+// the handler tests need; they are skipped without it.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("VITALINK_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("VITALINK_TEST_DATABASE_URL not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.PaymentPage{}, &models.IdempotencyKey{}, &models.Transaction{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{}, &models.WebhookAttempt{},
+		&models.Merchant{}, &models.APIKey{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testPage creates an open page with a unique PageUID.
+func testPage(t *testing.T, db *gorm.DB) *models.PaymentPage {
+	t.Helper()
+	page := &models.PaymentPage{
+		MerchantID:  "test-merchant",
+		PageUID:     fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		InvoiceNo:   "INV-TEST",
+		AmountCents: 1000,
+		Currency:    "USD",
+		Status:      models.StatusOpen,
+		CaptureMode: models.CaptureAutomatic,
+	}
+	if err := db.Create(page).Error; err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestRecordTransaction(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+
+	tests := []struct {
+		name    string
+		res     *gateway.Result
+		callErr error
+		want    string
+	}{
+		{"approved", &gateway.Result{Approved: true, AuthorizedCents: 1000, RefNo: "R1"}, nil, models.TransactionApproved},
+		{"partial", &gateway.Result{Approved: true, AuthorizedCents: 500, RefNo: "R2"}, nil, models.TransactionPartial},
+		{"declined", &gateway.Result{Status: "Declined", HTTPStatus: http.StatusOK}, nil, models.TransactionDeclined},
+		{"call failed", nil, errors.New("connection reset"), models.TransactionError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recordTransaction(db, page, models.TransactionSale, 1000, 0, tt.res, tt.callErr)
+			if got.ID == 0 {
+				t.Fatal("transaction was not saved")
+			}
+			if got.Status != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+			if tt.callErr != nil && got.Error != tt.callErr.Error() {
+				t.Errorf("error = %q, want %q", got.Error, tt.callErr)
+			}
+			if tt.res != nil && got.RefNo != tt.res.RefNo {
+				t.Errorf("ref_no = %q, want %q", got.RefNo, tt.res.RefNo)
+			}
+		})
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	db := testDB(t)
+	page := testPage(t, db)
+	sale := recordTransaction(db, page, models.TransactionSale, 1000, 0, &gateway.Result{Approved: true, RefNo: "R1"}, nil)
+	refund := recordTransaction(db, page, models.TransactionRefund, 400, 0, &gateway.Result{Approved: true, RefNo: "R2"}, nil)
+	testPage(t, db) // another page's ledger stays out of the list
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	c.SetParamNames("merchant_id", "page_uid")
+	c.SetParamValues(page.MerchantID, page.PageUID)
+	if err := handleListTransactions(c, db); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Data []models.Transaction `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Data) != 2 || body.Data[0].ID != sale.ID || body.Data[1].ID != refund.ID {
+		t.Errorf("transactions = %+v, want the sale then the refund", body.Data)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+	"vitalink/internal/webhooks"
+)
+
+func handleCreateWebhook(c echo.Context, db *gorm.DB) error {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": err.Error()})
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return c.JSON(http.StatusBadRequest, map[string]any{"error": "url must be an absolute https URL"})
+	}
+	for i, e := range req.Events {
+		if !webhooks.IsEvent(e) {
+			return c.JSON(http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("events[%d] unknown event %q", i, e), "allowed": webhooks.Events})
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "secret generation failed"})
+	}
+	ep := models.WebhookEndpoint{
+		MerchantID: c.Param("merchant_id"),
+		URL:        u.String(),
+		Secret:     secret,
+		Events:     strings.Join(req.Events, ","),
+		Active:     true,
+	}
+	if err := db.Create(&ep).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "create failed", "details": err.Error()})
+	}
+
+	// The secret is only ever shown here.
+	return c.JSON(http.StatusCreated, map[string]any{
+		"endpoint": ep,
+		"secret":   secret,
+	})
+}
+
+func handleListWebhooks(c echo.Context, db *gorm.DB) error {
+	var eps []models.WebhookEndpoint
+	if err := db.Order("id ASC").Find(&eps, "merchant_id = ?", c.Param("merchant_id")).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": eps})
+}
+
+func findWebhook(c echo.Context, db *gorm.DB) (*models.WebhookEndpoint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var ep models.WebhookEndpoint
+	if err := db.First(&ep, "id = ? AND merchant_id = ?", id, c.Param("merchant_id")).Error; err != nil {
+		return nil, err
+	}
+	return &ep, nil
+}
+
+func handleDeleteWebhook(c echo.Context, db *gorm.DB) error {
+	ep, err := findWebhook(c, db)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "webhook endpoint not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	// Deactivate rather than delete so the delivery log keeps its endpoint.
+	if err := db.Model(ep).Update("active", false).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func handleListWebhookDeliveries(c echo.Context, db *gorm.DB) error {
+	ep, err := findWebhook(c, db)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]any{"error": "webhook endpoint not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := db.Order("id DESC").Limit(100).Find(&deliveries, "endpoint_id = ?", ep.ID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+	}
+	ids := make([]uint, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+	var attempts []models.WebhookAttempt
+	if len(ids) > 0 {
+		if err := db.Order("id ASC").Find(&attempts, "delivery_id IN ?", ids).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]any{"error": "database error"})
+		}
+	}
+	byDelivery := map[uint][]models.WebhookAttempt{}
+	for _, a := range attempts {
+		byDelivery[a.DeliveryID] = append(byDelivery[a.DeliveryID], a)
+	}
+
+	out := make([]map[string]any, len(deliveries))
+	for i, d := range deliveries {
+		out[i] = map[string]any{"delivery": d, "attempts": byDelivery[d.ID]}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"data": out})
+}
@@ -0,0 +1,206 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"vitalink/internal/currency"
+)
+
+// Row is one line of a settlement file. Err is set when the line could not be
+// read; the other fields are then best effort.
+type Row struct {
+	Line      int
+	InvoiceNo string
+	RefNo     string
+	Currency  string
+	// Amount is the text from the file and AmountCents its value in minor
+	// units. Refunds and chargebacks may be negative; they are matched on
+	// the absolute amount.
+	Amount      string
+	AmountCents int64
+	Date        *time.Time
+	Err         error
+}
+
+// columns maps the header names processors use, normalized by headerKey, to
+// the field they hold.
+var columns = map[string]string{
+	"invoiceno":        "invoice",
+	"invoice":          "invoice",
+	"invoicenumber":    "invoice",
+	"refno":            "ref",
+	"ref":              "ref",
+	"reference":        "ref",
+	"referencenumber":  "ref",
+	"transactionid":    "ref",
+	"amount":           "amount",
+	"settledamount":    "amount",
+	"settlementamount": "amount",
+	"currency":         "currency",
+	"date":             "date",
+	"transactiondate":  "date",
+	"settlementdate":   "date",
+	"settledat":        "date",
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"01/02/2006",
+	"1/2/2006",
+	"01/02/2006 15:04:05",
+}
+
+func headerKey(h string) string {
+	h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	return strings.NewReplacer(" ", "", "_", "", "-", "", ".", "").Replace(h)
+}
+
+// Parse reads a settlement CSV. The first line must be a header naming an
+// amount column and an invoice or reference column; currency (USD when absent)
+// and date columns are optional. Bad lines come back as rows with Err set rather than failing
+// the whole file.
+func Parse(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("settlement file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	idx := map[string]int{}
+	for i, h := range header {
+		if field, ok := columns[headerKey(h)]; ok {
+			if _, dup := idx[field]; !dup {
+				idx[field] = i
+			}
+		}
+	}
+	if _, ok := idx["amount"]; !ok {
+		return nil, errors.New("settlement file has no amount column")
+	}
+	_, hasInvoice := idx["invoice"]
+	_, hasRef := idx["ref"]
+	if !hasInvoice && !hasRef {
+		return nil, errors.New("settlement file needs an invoice or reference column")
+	}
+
+	var rows []Row
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, err
+			}
+			rows = append(rows, Row{Line: perr.StartLine, Err: perr.Err})
+			continue
+		}
+		if blank(rec) {
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, parseRow(line, rec, idx))
+	}
+	return rows, nil
+}
+
+func parseRow(line int, rec []string, idx map[string]int) Row {
+	get := func(field string) string {
+		i, ok := idx[field]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	row := Row{
+		Line:      line,
+		InvoiceNo: get("invoice"),
+		RefNo:     get("ref"),
+		Currency:  strings.ToUpper(get("currency")),
+	}
+	if row.InvoiceNo == "" && row.RefNo == "" {
+		row.Err = errors.New("no invoice or reference")
+		return row
+	}
+	cur := currency.Or(row.Currency)
+	if row.Currency != "" {
+		c, ok := currency.Lookup(row.Currency)
+		if !ok {
+			row.Err = fmt.Errorf("unknown currency %q", row.Currency)
+			return row
+		}
+		cur = c
+	}
+	row.Amount = get("amount")
+	amount, err := parseAmount(cur, row.Amount)
+	if err != nil {
+		row.Err = err
+		return row
+	}
+	row.AmountCents = amount
+
+	if s := get("date"); s != "" {
+		d, err := parseDate(s)
+		if err != nil {
+			row.Err = err
+			return row
+		}
+		row.Date = &d
+	}
+	return row
+}
+
+// parseAmount accepts "12.34", "-12.34", "$1,234.50" and the accounting
+// style "(12.34)" for negatives.
+func parseAmount(cur currency.Currency, s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("missing amount")
+	}
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		neg, s = true, s[1:len(s)-1]
+	}
+	if strings.HasPrefix(s, "-") {
+		neg, s = true, s[1:]
+	}
+	minor, err := cur.ParseDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+func blank(rec []string) bool {
+	for _, v := range rec {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
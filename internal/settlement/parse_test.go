@@ -0,0 +1,95 @@
+package settlement
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	file := "\ufeffInvoice No,Reference,Settled Amount,Currency,Settlement Date\n" +
+		"INV-1,R1,\"$1,234.50\",usd,2024-03-01\n" +
+		"\n" +
+		"INV-2,R2,(12.34),,03/02/2024\n" +
+		"INV-3,R3,500,JPY,\n" +
+		",,1.00,USD,\n" +
+		"INV-5,R5,1.00,XXX,\n" +
+		"INV-6,R6,1.234,USD,\n" +
+		"INV-7,R7,1.00,USD,someday\n"
+
+	rows, err := Parse(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		line    int
+		invoice string
+		ref     string
+		cur     string
+		cents   int64
+		errText string
+	}{
+		{2, "INV-1", "R1", "USD", 123450, ""},
+		{4, "INV-2", "R2", "", -1234, ""},
+		{5, "INV-3", "R3", "JPY", 500, ""},
+		{6, "", "", "USD", 0, "no invoice or reference"},
+		{7, "INV-5", "R5", "XXX", 0, "unknown currency"},
+		{8, "INV-6", "R6", "USD", 0, "decimal places"},
+		{9, "INV-7", "R7", "USD", 100, "unrecognized date"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(rows), len(want), rows)
+	}
+	for i, w := range want {
+		r := rows[i]
+		if r.Line != w.line || r.InvoiceNo != w.invoice || r.RefNo != w.ref || r.Currency != w.cur {
+			t.Errorf("row %d = line %d %q/%q %q, want line %d %q/%q %q",
+				i, r.Line, r.InvoiceNo, r.RefNo, r.Currency, w.line, w.invoice, w.ref, w.cur)
+		}
+		if w.errText != "" {
+			if r.Err == nil || !strings.Contains(r.Err.Error(), w.errText) {
+				t.Errorf("row %d error = %v, want it to mention %q", i, r.Err, w.errText)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("row %d error = %v", i, r.Err)
+		}
+		if r.AmountCents != w.cents {
+			t.Errorf("row %d cents = %d, want %d", i, r.AmountCents, w.cents)
+		}
+	}
+	if rows[0].Date == nil || rows[0].Date.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("row 0 date = %v", rows[0].Date)
+	}
+	if rows[1].Date == nil || rows[1].Date.Format("2006-01-02") != "2024-03-02" {
+		t.Errorf("row 1 date = %v", rows[1].Date)
+	}
+}
+
+func TestParseHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name, file, want string
+	}{
+		{"empty", "", "empty"},
+		{"no amount", "invoice,currency\nINV-1,USD\n", "no amount column"},
+		{"no key", "amount,currency\n1.00,USD\n", "invoice or reference"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBadQuoting(t *testing.T) {
+	rows, err := Parse(strings.NewReader("ref,amount\nR1,\"1.00\nR2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Err == nil || rows[0].Line != 2 {
+		t.Errorf("rows = %+v, want one invalid row on line 2", rows)
+	}
+}
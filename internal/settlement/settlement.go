@@ -0,0 +1,402 @@
+// Package settlement checks processor settlement files against the
+// transaction ledger and keeps the result of each check.
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"vitalink/internal/currency"
+	"vitalink/internal/models"
+)
+
+// settledKinds are the ledger entries that move money and so appear in a
+// settlement file. Authorizations only do once captured.
+var settledKinds = []string{models.TransactionSale, models.TransactionCapture, models.TransactionRefund}
+
+// ErrInvalidFile wraps errors from a settlement file that cannot be read.
+var ErrInvalidFile = errors.New("invalid settlement file")
+
+// Import parses a settlement file for merchantID, matches it against the
+// ledger and stores the run with its exceptions.
+//
+// Rows match a transaction by RefNo, or failing that by the page's InvoiceNo.
+// When the file has dates they are taken as transaction dates, and approved
+// transactions in that range the file does not mention are reported too.
+func Import(db *gorm.DB, merchantID, filename string, r io.Reader) (*models.SettlementRun, []models.SettlementException, error) {
+	rows, err := Parse(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+
+	run := &models.SettlementRun{MerchantID: merchantID, Filename: filename, Rows: len(rows)}
+	for _, row := range rows {
+		if row.Err != nil || row.Date == nil {
+			continue
+		}
+		if run.From == nil || row.Date.Before(*run.From) {
+			d := *row.Date
+			run.From = &d
+		}
+		if run.To == nil || row.Date.After(*run.To) {
+			d := *row.Date
+			run.To = &d
+		}
+	}
+
+	l, err := loadLedger(db, merchantID, rows, run.From, run.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	exceptions := l.match(rows)
+	run.Exceptions = len(exceptions)
+	run.Matched = l.matchedClean
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		for i := range exceptions {
+			exceptions[i].RunID = run.ID
+		}
+		if len(exceptions) > 0 {
+			return tx.CreateInBatches(exceptions, 200).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return run, exceptions, nil
+}
+
+// ledger holds the approved transactions a file may refer to.
+type ledger struct {
+	byRef     map[string][]*models.Transaction
+	byInvoice map[string][]*models.Transaction
+	// inRange are the transactions dated within the file's range.
+	inRange []*models.Transaction
+	pages   map[string]models.PaymentPage
+
+	matched      map[uint]int // transaction ID -> row that settled it
+	matchedClean int
+}
+
+func loadLedger(db *gorm.DB, merchantID string, rows []Row, from, to *time.Time) (*ledger, error) {
+	l := &ledger{
+		byRef:     map[string][]*models.Transaction{},
+		byInvoice: map[string][]*models.Transaction{},
+		pages:     map[string]models.PaymentPage{},
+		matched:   map[uint]int{},
+	}
+
+	var refs, invoices []string
+	for _, row := range rows {
+		if row.RefNo != "" {
+			refs = append(refs, row.RefNo)
+		}
+		if row.InvoiceNo != "" {
+			invoices = append(invoices, row.InvoiceNo)
+		}
+	}
+
+	var pageUIDs []string
+	for _, batch := range batches(invoices) {
+		var pages []models.PaymentPage
+		if err := db.Where("merchant_id = ? AND invoice_no IN ?", merchantID, batch).Find(&pages).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range pages {
+			l.pages[p.PageUID] = p
+			pageUIDs = append(pageUIDs, p.PageUID)
+		}
+	}
+
+	// Transactions are looked up by ref, by page and by date in separate
+	// queries, each IN list in batches, and merged.
+	approved := func() *gorm.DB {
+		return db.Where("merchant_id = ? AND status = ? AND kind IN ?", merchantID, models.TransactionApproved, settledKinds)
+	}
+	found := map[uint]models.Transaction{}
+	collect := func(q *gorm.DB) error {
+		var txs []models.Transaction
+		if err := q.Find(&txs).Error; err != nil {
+			return err
+		}
+		for _, t := range txs {
+			found[t.ID] = t
+		}
+		return nil
+	}
+	for _, batch := range batches(refs) {
+		if err := collect(approved().Where("ref_no IN ?", batch)); err != nil {
+			return nil, err
+		}
+	}
+	for _, batch := range batches(pageUIDs) {
+		if err := collect(approved().Where("page_uid IN ?", batch)); err != nil {
+			return nil, err
+		}
+	}
+	if from != nil {
+		// Dates in the file are days; take all of the last one.
+		if err := collect(approved().Where("created_at >= ? AND created_at < ?", *from, to.AddDate(0, 0, 1))); err != nil {
+			return nil, err
+		}
+	}
+	txs := make([]models.Transaction, 0, len(found))
+	for _, t := range found {
+		txs = append(txs, t)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+
+	// Pages of transactions found only by ref or date, for invoice numbers
+	// in the report.
+	var missing []string
+	for _, t := range txs {
+		if _, ok := l.pages[t.PageUID]; !ok {
+			missing = append(missing, t.PageUID)
+		}
+	}
+	for _, batch := range batches(missing) {
+		var more []models.PaymentPage
+		if err := db.Where("merchant_id = ? AND page_uid IN ?", merchantID, batch).Find(&more).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range more {
+			l.pages[p.PageUID] = p
+		}
+	}
+
+	for i := range txs {
+		t := &txs[i]
+		if t.RefNo != "" {
+			l.byRef[t.RefNo] = append(l.byRef[t.RefNo], t)
+		}
+		if p, ok := l.pages[t.PageUID]; ok && p.InvoiceNo != "" {
+			l.byInvoice[p.InvoiceNo] = append(l.byInvoice[p.InvoiceNo], t)
+		}
+		if from != nil && !t.CreatedAt.Before(*from) && t.CreatedAt.Before(to.AddDate(0, 0, 1)) {
+			l.inRange = append(l.inRange, t)
+		}
+	}
+	return l, nil
+}
+
+// inBatchSize bounds each IN list so a large file stays far below the
+// 65535 bind parameters Postgres allows in one statement.
+const inBatchSize = 1000
+
+// batches splits values, without duplicates, into slices of at most
+// inBatchSize.
+func batches(values []string) [][]string {
+	seen := make(map[string]bool, len(values))
+	var out [][]string
+	var cur []string
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		cur = append(cur, v)
+		if len(cur) == inBatchSize {
+			out = append(out, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func (l *ledger) match(rows []Row) []models.SettlementException {
+	var out []models.SettlementException
+	for _, row := range rows {
+		ex := models.SettlementException{
+			Row:          row.Line,
+			InvoiceNo:    row.InvoiceNo,
+			RefNo:        row.RefNo,
+			Currency:     row.Currency,
+			SettledCents: row.AmountCents,
+		}
+		if row.Err != nil {
+			ex.Kind, ex.Detail = models.ExceptionInvalidRow, row.Err.Error()
+			out = append(out, ex)
+			continue
+		}
+
+		t := l.find(row)
+		if t == nil {
+			ex.Kind, ex.Detail = models.ExceptionMissingInLedger, "no approved transaction matches this row"
+			out = append(out, ex)
+			continue
+		}
+		settled := settledCents(row, t)
+		ex.SettledCents = settled
+		l.describe(&ex, t)
+		if first, dup := l.matched[t.ID]; dup {
+			ex.Kind, ex.Detail = models.ExceptionDuplicate, fmt.Sprintf("transaction already settled by row %d", first)
+			out = append(out, ex)
+			continue
+		}
+		l.matched[t.ID] = row.Line
+
+		if row.Currency != "" && row.Currency != t.Currency {
+			ex.Kind, ex.Detail = models.ExceptionCurrencyMismatch, fmt.Sprintf("settled in %s, recorded in %s", row.Currency, t.Currency)
+			out = append(out, ex)
+			continue
+		}
+		if abs(settled) != recordedCents(t) {
+			ex.Kind = models.ExceptionAmountMismatch
+			ex.Detail = fmt.Sprintf("settled %s, recorded %s",
+				currency.Or(t.Currency).Format(abs(settled)), currency.Or(t.Currency).Format(recordedCents(t)))
+			out = append(out, ex)
+			continue
+		}
+		l.matchedClean++
+	}
+
+	for _, t := range l.inRange {
+		if _, ok := l.matched[t.ID]; ok {
+			continue
+		}
+		// A voided sale never settles.
+		if p, ok := l.pages[t.PageUID]; ok && p.Status == models.StatusVoided {
+			continue
+		}
+		ex := models.SettlementException{
+			Kind:   models.ExceptionMissingInSettlement,
+			RefNo:  t.RefNo,
+			Detail: fmt.Sprintf("approved %s on %s is not in the file", t.Kind, t.CreatedAt.Format("2006-01-02")),
+		}
+		l.describe(&ex, t)
+		out = append(out, ex)
+	}
+	return out
+}
+
+// find picks the transaction a row settles, preferring one no other row has
+// claimed and one for the same amount.
+func (l *ledger) find(row Row) *models.Transaction {
+	if row.RefNo != "" {
+		if ts := l.byRef[row.RefNo]; len(ts) > 0 {
+			return l.pick(ts, row)
+		}
+	}
+	if row.InvoiceNo != "" {
+		if ts := l.byInvoice[row.InvoiceNo]; len(ts) > 0 {
+			return l.pick(ts, row)
+		}
+	}
+	return nil
+}
+
+func (l *ledger) pick(ts []*models.Transaction, row Row) *models.Transaction {
+	// A negative row is money going back, so it settles a refund rather than
+	// the sale on the same invoice.
+	var sameSign []*models.Transaction
+	for _, t := range ts {
+		if (t.Kind == models.TransactionRefund) == (row.AmountCents < 0) {
+			sameSign = append(sameSign, t)
+		}
+	}
+	if len(sameSign) > 0 {
+		ts = sameSign
+	}
+
+	var unclaimed *models.Transaction
+	for _, t := range ts {
+		if _, taken := l.matched[t.ID]; taken {
+			continue
+		}
+		if recordedCents(t) == abs(settledCents(row, t)) {
+			return t
+		}
+		if unclaimed == nil {
+			unclaimed = t
+		}
+	}
+	if unclaimed != nil {
+		return unclaimed
+	}
+	return ts[0]
+}
+
+func (l *ledger) describe(ex *models.SettlementException, t *models.Transaction) {
+	id := t.ID
+	ex.TransactionID = &id
+	ex.PageUID = t.PageUID
+	ex.RecordedCents = recordedCents(t)
+	if ex.Currency == "" {
+		ex.Currency = t.Currency
+	}
+	if ex.InvoiceNo == "" {
+		ex.InvoiceNo = l.pages[t.PageUID].InvoiceNo
+	}
+}
+
+// settledCents is the row's amount in minor units of t's currency. A row with
+// no currency is read in the currency the transaction was taken in.
+func settledCents(row Row, t *models.Transaction) int64 {
+	if row.Currency != "" {
+		return row.AmountCents
+	}
+	if v, err := parseAmount(currency.Or(t.Currency), row.Amount); err == nil {
+		return v
+	}
+	return row.AmountCents
+}
+
+// recordedCents is what the ledger says moved: the approved amount of a sale,
+// which the processor may report separately from what was asked.
+func recordedCents(t *models.Transaction) int64 {
+	if t.Kind == models.TransactionSale && t.AuthorizedCents > 0 {
+		return t.AuthorizedCents
+	}
+	return t.AmountCents
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// WriteReport writes exceptions as CSV, amounts in major units.
+func WriteReport(w io.Writer, exceptions []models.SettlementException) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "row", "invoice_no", "ref_no", "currency", "settled_amount", "recorded_amount", "transaction_id", "page_uid", "detail"})
+	for _, ex := range exceptions {
+		cur := currency.Or(ex.Currency)
+		row, txID := "", ""
+		if ex.Row > 0 {
+			row = strconv.Itoa(ex.Row)
+		}
+		if ex.TransactionID != nil {
+			txID = strconv.FormatUint(uint64(*ex.TransactionID), 10)
+		}
+		cw.Write([]string{
+			ex.Kind,
+			row,
+			ex.InvoiceNo,
+			ex.RefNo,
+			cur.Code,
+			cur.FormatDecimal(ex.SettledCents),
+			cur.FormatDecimal(ex.RecordedCents),
+			txID,
+			ex.PageUID,
+			ex.Detail,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,147 @@
+package settlement
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"vitalink/internal/models"
+)
+
+func testLedger(ts ...*models.Transaction) *ledger {
+	l := &ledger{
+		byRef:     map[string][]*models.Transaction{},
+		byInvoice: map[string][]*models.Transaction{},
+		pages:     map[string]models.PaymentPage{},
+		matched:   map[uint]int{},
+	}
+	for _, t := range ts {
+		inv := "INV-" + t.PageUID
+		l.byRef[t.RefNo] = append(l.byRef[t.RefNo], t)
+		l.byInvoice[inv] = append(l.byInvoice[inv], t)
+		l.pages[t.PageUID] = models.PaymentPage{PageUID: t.PageUID, InvoiceNo: inv, Status: models.StatusPaid}
+		l.inRange = append(l.inRange, t)
+	}
+	return l
+}
+
+func sale(id uint, page, ref string, cents int64, cur string) *models.Transaction {
+	t := &models.Transaction{
+		PageUID:     page,
+		RefNo:       ref,
+		Kind:        models.TransactionSale,
+		Status:      models.TransactionApproved,
+		AmountCents: cents,
+		Currency:    cur,
+	}
+	t.ID = id
+	t.CreatedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return t
+}
+
+func TestMatch(t *testing.T) {
+	refund := sale(4, "p1", "R1-REF", 300, "USD")
+	refund.Kind = models.TransactionRefund
+	l := testLedger(
+		sale(1, "p1", "R1", 1000, "USD"),
+		sale(2, "p2", "R2", 2000, "USD"),
+		sale(3, "p3", "R3", 500, "JPY"),
+		refund,
+		sale(5, "p5", "R5", 700, "USD"),
+		sale(6, "p6", "R6", 900, "USD"),
+	)
+	voided := l.pages["p6"]
+	voided.Status = models.StatusVoided
+	l.pages["p6"] = voided
+
+	rows := []Row{
+		{Line: 2, RefNo: "R1", Currency: "USD", Amount: "10.00", AmountCents: 1000},
+		{Line: 3, InvoiceNo: "INV-p2", Amount: "19.99", AmountCents: 1999},
+		{Line: 4, RefNo: "R3", Amount: "500", AmountCents: 50000},
+		{Line: 5, RefNo: "R1", Currency: "USD", Amount: "10.00", AmountCents: 1000},
+		{Line: 6, RefNo: "NOPE", Currency: "USD", Amount: "1.00", AmountCents: 100},
+		{Line: 7, RefNo: "R1-REF", Currency: "EUR", Amount: "-3.00", AmountCents: -300},
+		{Line: 8, RefNo: "R9", Err: fmt.Errorf("missing amount")},
+	}
+	got := l.match(rows)
+
+	want := []struct {
+		kind string
+		row  int
+		txn  uint
+	}{
+		{models.ExceptionAmountMismatch, 3, 2},
+		{models.ExceptionDuplicate, 5, 1},
+		{models.ExceptionMissingInLedger, 6, 0},
+		{models.ExceptionCurrencyMismatch, 7, 4},
+		{models.ExceptionInvalidRow, 8, 0},
+		{models.ExceptionMissingInSettlement, 0, 5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d exceptions, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		ex := got[i]
+		var txn uint
+		if ex.TransactionID != nil {
+			txn = *ex.TransactionID
+		}
+		if ex.Kind != w.kind || ex.Row != w.row || txn != w.txn {
+			t.Errorf("exception %d = %s row %d txn %d, want %s row %d txn %d",
+				i, ex.Kind, ex.Row, txn, w.kind, w.row, w.txn)
+		}
+	}
+	// R1 and the JPY row read in the transaction's own currency are clean.
+	if l.matchedClean != 2 {
+		t.Errorf("matchedClean = %d, want 2", l.matchedClean)
+	}
+	if got[0].InvoiceNo != "INV-p2" || got[0].SettledCents != 1999 || got[0].RecordedCents != 2000 {
+		t.Errorf("amount mismatch = %+v", got[0])
+	}
+}
+
+func TestMatchPrefersRefundForNegativeRow(t *testing.T) {
+	refund := sale(2, "p1", "", 1000, "USD")
+	refund.Kind = models.TransactionRefund
+	l := testLedger(sale(1, "p1", "", 1000, "USD"), refund)
+
+	got := l.match([]Row{
+		{Line: 2, InvoiceNo: "INV-p1", Currency: "USD", Amount: "-10.00", AmountCents: -1000},
+		{Line: 3, InvoiceNo: "INV-p1", Currency: "USD", Amount: "10.00", AmountCents: 1000},
+	})
+	if len(got) != 0 {
+		t.Errorf("exceptions = %+v, want none", got)
+	}
+	if l.matched[2] != 2 || l.matched[1] != 3 {
+		t.Errorf("matched = %v, want refund by row 2 and sale by row 3", l.matched)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	if got := batches(nil); len(got) != 0 {
+		t.Errorf("batches(nil) = %v, want none", got)
+	}
+
+	values := make([]string, 0, 2*inBatchSize+10)
+	for i := 0; i < inBatchSize*2+5; i++ {
+		values = append(values, fmt.Sprint(i))
+	}
+	values = append(values, "0", "1", "2", "3", "4")
+
+	got := batches(values)
+	if len(got) != 3 {
+		t.Fatalf("got %d batches, want 3", len(got))
+	}
+	if len(got[0]) != inBatchSize || len(got[1]) != inBatchSize || len(got[2]) != 5 {
+		t.Errorf("batch sizes = %d, %d, %d", len(got[0]), len(got[1]), len(got[2]))
+	}
+	seen := map[string]bool{}
+	for _, b := range got {
+		for _, v := range b {
+			if seen[v] {
+				t.Fatalf("%q appears twice", v)
+			}
+			seen[v] = true
+		}
+	}
+}
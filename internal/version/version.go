@@ -0,0 +1,53 @@
+// Package version reports how the running binary was built. The variables
+// are set at link time, e.g.
+//
+//	go build -ldflags "-X vitalink/internal/version.Version=v1.4.0 \
+//	  -X vitalink/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X vitalink/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	// Modified is true when the binary was built from a dirty checkout. It is
+	// only known for builds that embed VCS information.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Get returns the build information, filling Commit and BuildTime from the
+// VCS stamp Go embeds when they were not set with -ldflags.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
@@ -0,0 +1,202 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"vitalink/internal/models"
+	"vitalink/internal/netguard"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it is marked failed.
+	MaxAttempts = 10
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	// leaseFor keeps other dispatchers away from a delivery while it is in flight.
+	leaseFor  = 2 * time.Minute
+	batchSize = 20
+)
+
+// Dispatcher sends queued deliveries and reschedules failures with
+// exponential backoff.
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+// NewDispatcher returns a Dispatcher whose client only dials public addresses
+// and does not follow redirects, since endpoint URLs come from merchants.
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{db: db, client: &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: netguard.PublicOnly}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Run polls for due deliveries every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce delivers one batch of due deliveries.
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	due, err := d.claimDue(ctx)
+	if err != nil {
+		log.Println("Error claiming webhook deliveries:", err)
+		return
+	}
+	for i := range due {
+		d.deliver(ctx, &due[i])
+	}
+}
+
+// claimDue picks due deliveries and pushes their next attempt out by leaseFor,
+// so a second instance polling at the same time skips them.
+func (d *Dispatcher) claimDue(ctx context.Context) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookPending, now).
+			Order("next_attempt_at ASC").
+			Limit(batchSize).
+			Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(due))
+		for i, dl := range due {
+			ids[i] = dl.ID
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(leaseFor)).Error
+	})
+	return due, err
+}
+
+func backoff(attempts int) time.Duration {
+	b := baseBackoff
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if b >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return b
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, dl *models.WebhookDelivery) {
+	var ep models.WebhookEndpoint
+	err := d.db.WithContext(ctx).First(&ep, dl.EndpointID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !ep.Active) {
+		d.db.WithContext(ctx).Model(dl).Updates(map[string]any{
+			"status":     models.WebhookFailed,
+			"last_error": "endpoint removed or disabled",
+		})
+		return
+	}
+	if err != nil {
+		// Not the endpoint's fault, so it does not count as an attempt.
+		log.Println("Error loading webhook endpoint, rescheduling:", dl.ID, err)
+		if err := d.db.WithContext(ctx).Model(dl).Update("next_attempt_at", time.Now().Add(backoff(dl.Attempts+1))).Error; err != nil {
+			log.Println("Error updating webhook delivery:", dl.ID, err)
+		}
+		return
+	}
+
+	start := time.Now()
+	code, sendErr := d.send(ctx, ep, dl)
+	attempt := models.WebhookAttempt{
+		DeliveryID: dl.ID,
+		HTTPStatus: code,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	if err := d.db.WithContext(ctx).Create(&attempt).Error; err != nil {
+		log.Println("Error logging webhook attempt:", dl.ID, err)
+	}
+
+	attempts := dl.Attempts + 1
+	updates := map[string]any{
+		"attempts":       attempts,
+		"last_http_code": code,
+		"last_error":     attempt.Error,
+	}
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		updates["status"] = models.WebhookSucceeded
+		updates["delivered_at"] = now
+	case attempts >= MaxAttempts:
+		updates["status"] = models.WebhookFailed
+		log.Println("Webhook delivery failed permanently:", dl.ID, dl.EventType, ep.URL, sendErr)
+	default:
+		updates["next_attempt_at"] = time.Now().Add(backoff(attempts))
+	}
+	if err := d.db.WithContext(ctx).Model(dl).Updates(updates).Error; err != nil {
+		log.Println("Error updating webhook delivery:", dl.ID, err)
+	}
+}
+
+// send posts the delivery and returns the response status. The response body
+// is drained but never kept: it is whatever the endpoint's host chose to
+// return, and the delivery log is readable by the merchant.
+func (d *Dispatcher) send(ctx context.Context, ep models.WebhookEndpoint, dl *models.WebhookDelivery) (int, error) {
+	if !strings.HasPrefix(ep.URL, "https://") {
+		return 0, errors.New("endpoint URL is not https")
+	}
+	body := []byte(dl.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("request build error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "VitaPay-Webhooks/1.0")
+	req.Header.Set(EventIDHeader, dl.EventID)
+	req.Header.Set(SignatureHeader, Sign(ep.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 2048))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,43 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vitalink/internal/models"
+)
+
+func TestSendRefusesNonPublicEndpoints(t *testing.T) {
+	called := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil)
+	dl := &models.WebhookDelivery{EventID: "evt_1", Payload: `{}`}
+	for _, url := range []string{srv.URL, strings.Replace(srv.URL, "https://", "http://", 1)} {
+		code, err := d.send(context.Background(), models.WebhookEndpoint{URL: url, Secret: "s"}, dl)
+		if err == nil || code != 0 {
+			t.Errorf("send(%s) = %d, %v; want it refused", url, code, err)
+		}
+	}
+	if called {
+		t.Error("endpoint on a loopback address was called")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if got := backoff(1); got != baseBackoff {
+		t.Errorf("backoff(1) = %s, want %s", got, baseBackoff)
+	}
+	if got := backoff(3); got != 4*baseBackoff {
+		t.Errorf("backoff(3) = %s, want %s", got, 4*baseBackoff)
+	}
+	if got := backoff(MaxAttempts * 2); got != maxBackoff {
+		t.Errorf("backoff(%d) = %s, want %s", MaxAttempts*2, got, maxBackoff)
+	}
+}
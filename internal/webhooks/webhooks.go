@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"vitalink/internal/models"
+)
+
+const (
+	EventPageCreated     = "page.created"
+	EventPagePaid        = "page.paid"
+	EventPageExpired     = "page.expired"
+	EventPaymentDeclined = "payment.declined"
+	EventRefundSucceeded = "refund.succeeded"
+	SignatureHeader      = "X-Vitalink-Signature"
+	EventIDHeader        = "X-Vitalink-Event-Id"
+	secretPrefix         = "whsec_"
+)
+
+var Events = []string{
+	EventPageCreated,
+	EventPagePaid,
+	EventPageExpired,
+	EventPaymentDeclined,
+	EventRefundSucceeded,
+}
+
+func IsEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON body posted to endpoints.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+func newEventID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value for body: the unix timestamp and an
+// HMAC-SHA256 over "<timestamp>.<body>", in the form "t=...,v1=...".
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(ep models.WebhookEndpoint, eventType string) bool {
+	if strings.TrimSpace(ep.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(ep.Events, ",") {
+		if strings.TrimSpace(e) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue queues eventType for every active endpoint of the merchant that
+// subscribes to it. Passing the caller's transaction makes the event commit or
+// roll back together with the state change that caused it; the work runs in a
+// savepoint so a webhook failure never poisons that transaction.
+func Enqueue(db *gorm.DB, merchantID, eventType string, data any) error {
+	evt := Event{ID: newEventID(), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal webhook event: %w", err)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var endpoints []models.WebhookEndpoint
+		if err := tx.Find(&endpoints, "merchant_id = ? AND active = ?", merchantID, true).Error; err != nil {
+			return fmt.Errorf("load webhook endpoints: %w", err)
+		}
+		for _, ep := range endpoints {
+			if !subscribed(ep, eventType) {
+				continue
+			}
+			d := models.WebhookDelivery{
+				EndpointID:    ep.ID,
+				MerchantID:    merchantID,
+				EventID:       evt.ID,
+				EventType:     eventType,
+				Payload:       string(payload),
+				Status:        models.WebhookPending,
+				NextAttemptAt: evt.CreatedAt,
+			}
+			if err := tx.Create(&d).Error; err != nil {
+				return fmt.Errorf("queue webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Emit is Enqueue for callers that should not fail because of webhooks.
+func Emit(db *gorm.DB, merchantID, eventType string, data any) {
+	if err := Enqueue(db, merchantID, eventType, data); err != nil {
+		log.Println("Error queueing webhook:", merchantID, eventType, err)
+	}
+}
@@ -0,0 +1,44 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"page.paid"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		secret string
+		ts     time.Time
+		body   []byte
+		same   bool
+	}{
+		{"reference", "whsec_test", ts, body, true},
+		{"other secret", "whsec_other", ts, body, false},
+		{"other time", "whsec_test", ts.Add(time.Second), body, false},
+		{"other body", "whsec_test", ts, []byte(`{"type":"page.paid "}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Sign(tt.secret, tt.ts, tt.body)
+			if (got == want) != tt.same {
+				t.Errorf("Sign = %q, reference %q, want equal %v", got, want, tt.same)
+			}
+			if prefix := "t=" + strconv.FormatInt(tt.ts.Unix(), 10) + ",v1="; !strings.HasPrefix(got, prefix) {
+				t.Errorf("Sign = %q, want prefix %q", got, prefix)
+			}
+		})
+	}
+}
@@ -1,30 +1,138 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
+	"vitalink/internal/auth"
+	"vitalink/internal/config"
+	"vitalink/internal/gateway"
 	"vitalink/internal/models"
 	"vitalink/internal/server"
+	"vitalink/internal/settlement"
+	"vitalink/internal/version"
+	"vitalink/internal/webhooks"
 )
 
 func main() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" { log.Fatal("DATABASE_URL is required") }
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	cfg, err := config.Load()
+	if err != nil { log.Fatal("invalid configuration: ", err) }
+
+	// `vitalink healthcheck` probes /healthz on the configured address, for
+	// Docker HEALTHCHECK in an image with no shell or curl.
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		if err := healthcheck(cfg.Server.Addr); err != nil { log.Fatal(err) }
+		return
+	}
+
+	build := version.Get()
+	log.Println("Starting vitalink", build.Version, build.Commit, "in", cfg.Env, "environment")
+
+	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{})
 	if err != nil { log.Fatal(err) }
 
-	if err := db.AutoMigrate(&models.PaymentPage{}); err != nil { log.Fatal(err) }
+	if err := db.AutoMigrate(&models.PaymentPage{}, &models.IdempotencyKey{}, &models.Transaction{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{}, &models.WebhookAttempt{},
+		&models.Merchant{}, &models.APIKey{}, &models.SettlementRun{}, &models.SettlementException{}); err != nil { log.Fatal(err) }
+	if err := models.MigrateLegacyMoney(db); err != nil { log.Fatal(err) }
+
+	// `vitalink create-api-key <merchant_id> [name]` issues a key and exits.
+	if len(os.Args) > 1 && os.Args[1] == "create-api-key" {
+		if len(os.Args) < 3 { log.Fatal("usage: vitalink create-api-key <merchant_id> [name]") }
+		name := ""
+		if len(os.Args) > 3 { name = os.Args[3] }
+		key, _, err := auth.CreateKey(db, os.Args[2], name)
+		if err != nil { log.Fatal(err) }
+		fmt.Println(key)
+		return
+	}
 
-	e := server.Router(db)
+	// `vitalink import-settlement <merchant_id> <file.csv>` reconciles a
+	// settlement file and writes its exceptions report to stdout.
+	if len(os.Args) > 1 && os.Args[1] == "import-settlement" {
+		if len(os.Args) < 4 { log.Fatal("usage: vitalink import-settlement <merchant_id> <file.csv>") }
+		f, err := os.Open(os.Args[3])
+		if err != nil { log.Fatal(err) }
+		defer f.Close()
+		run, exceptions, err := settlement.Import(db, os.Args[2], filepath.Base(os.Args[3]), f)
+		if err != nil { log.Fatal(err) }
+		log.Printf("settlement run %d: %d rows, %d matched, %d exceptions", run.ID, run.Rows, run.Matched, run.Exceptions)
+		if err := settlement.WriteReport(os.Stdout, exceptions); err != nil { log.Fatal(err) }
+		return
+	}
 
-	serverPort := os.Getenv("PORT")
-	if serverPort == "" {
-		serverPort = "8080" // Default port
+	var gw gateway.PaymentGateway = gateway.NewDatacap(cfg.Gateway.DatacapBaseURL)
+	if cfg.Gateway.Provider == "mock" {
+		log.Println("Using mock payment gateway")
+		gw = gateway.NewMock()
 	}
 
-	if err := e.Start(":" + serverPort); err != nil { log.Fatal(err) }
+	gateways := gateway.Single(gw)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() { defer workers.Done(); run() }()
+	}
+	// Authorizations on manual capture pages are released after Workers.AuthVoidAfter.
+	runWorker(func() { server.RunAuthorizationSweeper(ctx, db, gateways, cfg.Workers.AuthSweepInterval, cfg.Workers.AuthVoidAfter, cfg.Gateway.Timeout) })
+	runWorker(func() { server.RunExpirySweeper(ctx, db, cfg.Workers.ExpirySweepInterval) })
+	runWorker(func() { server.RunChargeReconciler(ctx, db, gateways, cfg.Workers.ReconcileInterval, cfg.Workers.ReconcileAfter, cfg.Gateway.Timeout) })
+	runWorker(func() { webhooks.NewDispatcher(db).Run(ctx, cfg.Workers.WebhookInterval) })
+
+	assets := server.Assets{FS: embeddedAssets}
+	if dir := cfg.Server.AssetsDir; dir != "" {
+		log.Println("serving templates and public files from", dir, "with reload")
+		assets = server.Assets{FS: os.DirFS(dir), Reload: true}
+	}
+
+	inflight := server.NewInflight()
+	e := server.Router(cfg, db, gateways, assets, inflight)
+
+	go func() {
+		if err := e.Start(cfg.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) { log.Fatal(err) }
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil { log.Println("Error shutting down server:", err) }
+
+	// Handlers that outlived Shutdown keep running; give gateway calls that are
+	// already under way time to record their result before exiting.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancelDrain()
+	for _, req := range inflight.Drain(drainCtx) {
+		log.Println("Exiting with gateway request still in flight, reconcile it manually:", req)
+	}
+	workers.Wait()
+}
+
+func healthcheck(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil { return err }
+	if host == "" || host == "0.0.0.0" || host == "::" { host = "localhost" }
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil { return err }
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return fmt.Errorf("healthz answered %d", resp.StatusCode) }
+	return nil
 }